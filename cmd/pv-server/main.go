@@ -3,7 +3,11 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/emotes"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/filter"
 	"github.com/Isthatok74/PaperVolleyballServer/internal/server"
 )
 
@@ -18,6 +22,15 @@ func main() {
 	fmt.Println("Starting rate limiter...")
 	go server.ResetRateLimit()
 
+	fmt.Println("Loading word filter...")
+	setupWordFilter()
+
+	fmt.Println("Loading emote catalog...")
+	setupEmotes()
+
+	fmt.Println("Configuring host election...")
+	setupHostElection()
+
 	fmt.Println("Setting up function handlers...")
 	setupRoutesHTTP()
 	setupRoutesWS()
@@ -29,6 +42,42 @@ func main() {
 	serverData.Info.ListenForShutdown()
 }
 
+// load the list of words that are filtered out of display names and chat, if there is one
+func setupWordFilter() {
+	words, err := filter.LoadWordList(defs.BlockedWordsFile)
+	if err != nil {
+		fmt.Printf("No blocked words loaded, so display names and chat will not be filtered: %v\n", err)
+		return
+	}
+	policy, ok := filter.PolicyByName(defs.NameFilterPolicy)
+	if !ok {
+		fmt.Printf("Unknown name filter policy %q; rejecting names with blocked words instead\n", defs.NameFilterPolicy)
+	}
+	serverData.WordFilter = words
+	serverData.NamePolicy = policy
+	fmt.Printf("Loaded %d blocked words\n", words.Len())
+}
+
+// load the emote catalog from its file, and keep it up to date with the file
+func setupEmotes() {
+	catalog, err := emotes.LoadCatalog(defs.EmoteCatalogFile, emotes.DefaultEmotes())
+	if err != nil {
+		fmt.Printf("Using the built-in emotes until the catalog can be loaded: %v\n", err)
+	}
+	serverData.Emotes = catalog
+	go catalog.Watch(defs.EmoteReloadSeconds * time.Second)
+	fmt.Printf("Loaded %d emotes\n", catalog.Len())
+}
+
+// choose the rule for electing a new host when the current one leaves
+func setupHostElection() {
+	rule, ok := server.HostElectionByName(defs.HostElectionRule)
+	if !ok {
+		fmt.Printf("Unknown host election rule %q; electing the longest present player instead\n", defs.HostElectionRule)
+	}
+	serverData.HostElection = rule
+}
+
 // all of the HTTP routes are defined here.
 // * HTTP requests are used for requests that can be made from anywhere (e.g. a web browser). They typically involve a client simply sending a request, processing the request on the server, and then sending back a message to the client.
 func setupRoutesHTTP() {
@@ -36,6 +85,15 @@ func setupRoutesHTTP() {
 	// check the status of the server
 	http.Handle("/status", server.RateLimitHandler(http.HandlerFunc(serverData.HandleStatus), &(serverData.Info)))
 
+	// list the message types that clients may send over websockets
+	http.Handle("/messages", server.RateLimitHandler(http.HandlerFunc(serverData.HandleMessageTypes), &(serverData.Info)))
+
+	// list the public lobbies that players may join
+	http.Handle("/lobbies", server.RateLimitHandler(http.HandlerFunc(serverData.HandleLobbies), &(serverData.Info)))
+
+	// list the emotes that players may fire
+	http.Handle("/emotes", server.RateLimitHandler(http.HandlerFunc(serverData.HandleEmotes), &(serverData.Info)))
+
 	// any other route should still go through the middleware for checks
 	http.Handle("/", server.RateLimitHandler(http.HandlerFunc(serverData.HandleDefault), &(serverData.Info)))
 }
@@ -4,10 +4,134 @@ package defs
 const (
 	TimeoutGameMinutesWS   = 10
 	TimeoutPlayerMinutesWS = 2
+	ResumeGraceSeconds     = 30  // the time a disconnected player is kept on the server, so that they can resume their session with a new connection
+	SendQueueSize          = 256 // the maximum number of messages waiting to be sent to a client before it is considered too slow
+	MaxSnapshotHz          = 60  // the highest rate at which an instance may send batched snapshots of its players and ball
+	SnapshotHistorySize    = 32  // the number of recent snapshots kept per client, which may be used as the baseline of a delta snapshot once acknowledged
+)
+
+// slow client constants
+const (
+	SlowClientPolicyName = "drop" // how to handle clients whose send queue fills up: "drop" (transient frames to make room) or "disconnect"
+)
+
+// clock synchronization constants
+const (
+	RTTSmoothing    = 0.125 // the weight of a new round trip sample in a player's smoothed round trip time
+	JitterSmoothing = 0.25  // the weight of a new sample's deviation in a player's smoothed jitter
+	MaxRTTSampleMs  = 10000 // round trip samples longer than this (in milliseconds) are discarded as bogus
+)
+
+// lag compensation constants
+const (
+	PositionHistorySize = 64   // the number of recent positions kept for the ball and each player, to rewind them to the time a client perceived them
+	DefaultMaxRewindMs  = 200  // the furthest back in time (in milliseconds) that a touch is rewound to, unless the game's rules say otherwise
+	MaxRewindMs         = 1000 // the furthest back in time (in milliseconds) that a game may be configured to rewind touches to
+	MaxTouchReach       = 3    // the maximum distance between a player and the ball they touch
+	TouchPosTolerance   = 1.5  // the maximum distance between where a client reports touching the ball and where the server had the ball at that time
+	ServeHeight         = 1.5  // the height above the serving player's position at which a served ball starts
+)
+
+// movement validation constants (the speeds must mirror the player physics of the client)
+const (
+	BaseRunSpeed          = 4    // the horizontal speed of a player with no Speed stat
+	RunSpeedPerStat       = 0.5  // the horizontal speed added by each level of the Speed stat
+	BaseJumpSpeed         = 6    // the take-off speed of a jump by a player with no Jump stat
+	JumpSpeedPerStat      = 0.5  // the take-off speed added by each level of the Jump stat
+	MovementTolerance     = 1.25 // the factor by which a player may exceed their limits before being corrected, to allow for rounding and frame timing
+	MovementSlackSeconds  = 0.1  // the minimum time allowed between updates when checking the distance moved, so that updates that arrive bunched up are not mistaken for speeding
+	MaxMoveElapsedSeconds = 1    // the maximum time between updates considered when checking the distance moved, so that pausing updates doesn't allow a teleport
+	NetClearance          = 0.1  // the closest that a player is placed to the net when they are pushed back from crossing it
+	TeleportFactor        = 3    // the factor by which a player's move may exceed the allowed distance before it is rejected rather than clamped
+	MovementFlagThreshold = 20   // the number of movement violations after which a player is flagged for moderation
 )
 
 // game-related constants
 const (
 	MaxCourtSpawnX = 10 // the maximum x value to spawn a player on the court
 	MinCourtSpawnX = 1  // the minimum x value to spawn a player on the court
+	MaxTeamPlayers = 6  // the maximum number of players on each side of the court; anyone else may only spectate
+)
+
+// player attribute constants, used by the default attribute policy
+const (
+	MinStatLevel           = 0  // the lowest level of each player stat
+	MaxStatLevel           = 10 // the highest level of each player stat
+	StatBudget             = 20 // the maximum sum of a player's Strength, Speed, Jump and Size levels
+	MaxDisplayNameLength   = 16 // the maximum number of characters in a player's display name
+	MaxAccessoryCodeLength = 32 // the maximum length of a visual accessory code
+	NumEmblems             = 24 // the number of emblems that players may choose from, coded emblem_01 onwards
+	NumHairs               = 16 // the number of hairs that players may choose from, coded hair_01 onwards
+	NumLowerFaces          = 8  // the number of lower faces that players may choose from, coded lowerface_01 onwards
+	NumExpressions         = 8  // the number of expressions that players may choose from, coded expression_01 onwards
+)
+
+// moderation constants
+const (
+	BlockedWordsFile = "blocked_words.txt" // the file listing the words that are filtered out of display names and chat, one per line; nothing is filtered if it is missing
+	NameFilterPolicy = "replace"           // what to do with display names containing blocked words: "reject", "mask" or "replace" (with a guest name)
+)
+
+// private lobby constants
+const (
+	MaxLobbyPasswordLength    = 64 // the maximum number of characters in a lobby password
+	PasswordSaltBytes         = 16 // the number of random bytes in the salt of a lobby password's hash
+	InviteTokenBytes          = 16 // the number of random bytes in a lobby invite token
+	MaxPendingInvites         = 32 // the maximum number of unused invite tokens that a lobby may have at once
+	LobbyAttemptBurst         = 5  // the number of failed attempts to find or get into a lobby that a connection may make in quick succession
+	LobbyAttemptRefillSeconds = 10 // the time it takes for a connection to be allowed one more failed attempt, once it has used up its burst
+)
+
+// lobby browser constants
+const (
+	LobbyBrowserPageSize    = 20  // the number of public lobbies listed per page, unless a client asks for another number
+	MaxLobbyBrowserPageSize = 100 // the most public lobbies that may be listed on one page
+)
+
+// host constants
+const (
+	HostElectionRule = "longest" // how a new host is chosen when the current one leaves: "longest" (present) or "ping" (lowest round trip time)
+)
+
+// chat constants
+const (
+	MaxChatLength     = 200 // the maximum number of characters in a chat message
+	ChatBurst         = 5   // the number of chat messages that a player may send in quick succession
+	ChatRefillSeconds = 2   // the time it takes for a player to be allowed one more chat message, once they have used up their burst
+	ChatHistorySize   = 20  // the number of recent chat messages kept per lobby, which are sent to players who join later
+)
+
+// emote constants
+const (
+	EmoteCatalogFile   = "emotes.json" // the json file listing the emotes that players may fire; the built-in emotes are used while it is missing
+	EmoteReloadSeconds = 10            // how often the emote catalog file is checked for changes
+	EmoteCooldownMs    = 1500          // how long a player must wait between emotes, unless the emote sets its own cooldown
+)
+
+// lobby-related constants
+const (
+	MatchCountdownSeconds = 5 // the length of the countdown before a match starts once enough players are ready
+	MinPlayersToStart     = 2 // the minimum number of players in a lobby needed to start a match
+)
+
+// ball physics constants (these must mirror the values used by the client's physics engine)
+const (
+	BallTickRate      = 50    // the number of simulation steps per second for the server-side ball
+	BallSnapshotTicks = 5     // the number of simulation steps between authoritative ball snapshots sent to clients
+	Gravity           = -9.81 // the gravitational acceleration applied to the ball, before its gravity scale
+	BallRadius        = 0.5   // the radius of the ball
+	FloorY            = 0     // the y value of the court floor
+	NetPosX           = 0     // the x value at which the net stands
+	NetHeight         = 2.5   // the height of the top of the net above the floor
+	NetRestitution    = 0.3   // the fraction of horizontal speed kept by the ball when it bounces off the net
+	CourtHalfLength   = 12    // the distance from the net to the back line on either side; landing beyond it is out of bounds
+)
+
+// default match rules, used for any rule that is not configured when a game is created
+const (
+	DefaultPointsPerSet      = 25 // the points needed to win a regular set
+	DefaultPointsDecidingSet = 15 // the points needed to win the final deciding set
+	DefaultWinBy             = 2  // the minimum lead needed to win a set
+	DefaultBestOf            = 3  // the maximum number of sets played in a match
+	DefaultMaxTouches        = 3  // the maximum number of touches a team may make before sending the ball over the net
 )
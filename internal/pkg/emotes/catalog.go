@@ -0,0 +1,150 @@
+package emotes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Purpose: The catalog of emotes that players may fire, which clients animate above the player
+// * The catalog may be loaded from a json file, which is reloaded whenever it changes so that emotes can be added or retired without redeploying the server
+
+// an emote that players may fire
+type Emote struct {
+	Code       string `json:"Code"`       // the code that identifies the emote in messages
+	Text       string `json:"Text"`       // the text shown for the emote, e.g. "nice!"
+	CooldownMs int    `json:"CooldownMs"` // how long a player must wait after firing the emote before firing another (milliseconds); zero uses the server's default
+}
+
+// the emotes that are available if no catalog file is provided
+func DefaultEmotes() []Emote {
+	return []Emote{
+		{Code: "nice", Text: "nice!"},
+		{Code: "mybad", Text: "my bad"},
+		{Code: "mine", Text: "mine!"},
+		{Code: "thanks", Text: "thanks!"},
+		{Code: "gg", Text: "gg"},
+	}
+}
+
+// a set of emotes, which may be reloaded from its file while in use
+type Catalog struct {
+	path    string           // the file the catalog was loaded from; empty if it was created in code
+	modTime time.Time        // the modification time of the file when it was last loaded
+	emotes  map[string]Emote // key: emote.Code
+	mu      sync.RWMutex
+}
+
+// create a catalog from a list of emotes
+func NewCatalog(emotes []Emote) *Catalog {
+	c := &Catalog{}
+	c.set(emotes)
+	return c
+}
+
+// load a catalog from a json file containing a list of emotes
+// * the catalog holds the fallback emotes until the file exists and can be loaded; an error is returned along with the catalog only if the file exists but can't be read or is invalid
+func LoadCatalog(path string, fallback []Emote) (*Catalog, error) {
+	c := &Catalog{path: path}
+	c.set(fallback)
+	_, err := c.Reload()
+	return c, err
+}
+
+// reload the catalog from its file if the file has changed since it was last loaded; returns whether it was reloaded
+// * the current emotes are kept if there is no file, which is not an error, or if the file can't be read or is invalid
+func (c *Catalog) Reload() (bool, error) {
+	if len(c.path) == 0 {
+		return false, nil
+	}
+	info, err := os.Stat(c.path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	c.mu.RLock()
+	unchanged := info.ModTime().Equal(c.modTime)
+	c.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	// read and check the new list before swapping it in
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return false, err
+	}
+	var emotes []Emote
+	if err := json.Unmarshal(data, &emotes); err != nil {
+		return false, fmt.Errorf("unable to parse emote catalog %s: %w", c.path, err)
+	}
+	for i, emote := range emotes {
+		if len(emote.Code) == 0 {
+			return false, fmt.Errorf("emote %d in catalog %s has no code", i, c.path)
+		}
+	}
+	c.set(emotes)
+	c.mu.Lock()
+	c.modTime = info.ModTime()
+	c.mu.Unlock()
+	return true, nil
+}
+
+// reload the catalog from its file whenever it changes, checking at the given interval; runs until the program exits
+func (c *Catalog) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		reloaded, err := c.Reload()
+		if err != nil {
+			log.Printf("Unable to reload emote catalog: %v", err)
+		} else if reloaded {
+			log.Printf("Reloaded emote catalog with %d emotes", c.Len())
+		}
+	}
+}
+
+// replace the emotes of the catalog
+func (c *Catalog) set(emotes []Emote) {
+	byCode := make(map[string]Emote, len(emotes))
+	for _, emote := range emotes {
+		byCode[emote.Code] = emote
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.emotes = byCode
+}
+
+// returns the emote with the given code, or false if it is not in the catalog
+func (c *Catalog) Lookup(code string) (Emote, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	emote, ok := c.emotes[code]
+	return emote, ok
+}
+
+// returns the emotes in the catalog, ordered by code
+func (c *Catalog) List() []Emote {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	list := make([]Emote, 0, len(c.emotes))
+	for _, emote := range c.emotes {
+		list = append(list, emote)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Code < list[j].Code
+	})
+	return list
+}
+
+// returns the number of emotes in the catalog
+func (c *Catalog) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.emotes)
+}
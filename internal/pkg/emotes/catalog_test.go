@@ -0,0 +1,68 @@
+package emotes
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCatalogReloadsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "emotes.json")
+
+	// the fallback is used until the file exists, without that being an error
+	catalog, err := LoadCatalog(path, DefaultEmotes())
+	if err != nil {
+		t.Errorf("loading a missing catalog returned an error: %v", err)
+	}
+	if reloaded, err := catalog.Reload(); reloaded || err != nil {
+		t.Errorf("catalog reload without a file = %t, %v; want nothing done", reloaded, err)
+	}
+	if _, ok := catalog.Lookup("nice"); !ok || catalog.Len() != len(DefaultEmotes()) {
+		t.Errorf("catalog holds %d emotes; want the fallback", catalog.Len())
+	}
+
+	// write the file and reload it
+	write := func(contents string, modTime time.Time) {
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatalf("Error writing catalog: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Error setting catalog time: %v", err)
+		}
+	}
+	start := time.Now()
+	write(`[{"Code": "spike", "Text": "spike!", "CooldownMs": 3000}]`, start)
+	if reloaded, err := catalog.Reload(); !reloaded || err != nil {
+		t.Fatalf("catalog reload = %t, %v; want it reloaded", reloaded, err)
+	}
+	if emote, ok := catalog.Lookup("spike"); !ok || emote.CooldownMs != 3000 {
+		t.Errorf("emote = %+v, %t; want the one from the file", emote, ok)
+	}
+	if _, ok := catalog.Lookup("nice"); ok {
+		t.Errorf("fallback emote is still in the catalog after loading the file")
+	}
+	if reloaded, _ := catalog.Reload(); reloaded {
+		t.Errorf("catalog was reloaded without the file changing")
+	}
+
+	// an invalid file keeps the current emotes
+	write(`[{"Text": "no code"}]`, start.Add(time.Second))
+	if _, err := catalog.Reload(); err == nil {
+		t.Errorf("reloading an invalid catalog returned no error")
+	}
+	if list := catalog.List(); len(list) != 1 || list[0].Code != "spike" {
+		t.Errorf("catalog = %+v; want it unchanged by an invalid file", list)
+	}
+
+	// removing the file keeps the current emotes
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Error removing catalog: %v", err)
+	}
+	if reloaded, err := catalog.Reload(); reloaded || err != nil {
+		t.Errorf("catalog reload after removing the file = %t, %v; want nothing done", reloaded, err)
+	}
+	if _, ok := catalog.Lookup("spike"); !ok {
+		t.Errorf("catalog lost its emotes after the file was removed")
+	}
+}
@@ -0,0 +1,152 @@
+package filter
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Purpose: Filters for blocked words in text written by players, such as display names or chat
+// * A Filter only finds blocked words; what to do about them is decided by a Policy, so that each feature can choose its own
+// * Filters are pluggable: the WordList below matches a local list of words, and any other implementation (e.g. an external moderation service) may be used instead
+
+// a filter that finds blocked words in text
+type Filter interface {
+	Find(text string) []Span // returns where blocked words appear in the text, in order
+}
+
+// the position of a blocked word in text, in runes from its start
+type Span struct {
+	Start int // the offset of the first rune of the word
+	End   int // the offset just past the last rune of the word
+}
+
+// what to do with text that contains blocked words
+type Policy int
+
+const (
+	PolicyReject  Policy = iota // refuse the text
+	PolicyMask                  // replace the blocked words with asterisks
+	PolicyReplace               // replace the whole text with a substitute chosen by the caller (e.g. a generated guest name)
+)
+
+// returns the policy with the given name ("reject", "mask" or "replace"), or false if there is none
+func PolicyByName(name string) (Policy, bool) {
+	switch strings.ToLower(name) {
+	case "reject":
+		return PolicyReject, true
+	case "mask":
+		return PolicyMask, true
+	case "replace":
+		return PolicyReplace, true
+	default:
+		return PolicyReject, false
+	}
+}
+
+// returns whether the text contains any blocked words
+func Contains(f Filter, text string) bool {
+	return len(f.Find(text)) > 0
+}
+
+// returns the text with every blocked word replaced by asterisks
+func Mask(f Filter, text string) string {
+	runes := []rune(text)
+	for _, span := range f.Find(text) {
+		for i := span.Start; i < span.End; i++ {
+			runes[i] = '*'
+		}
+	}
+	return string(runes)
+}
+
+// apply a policy to text; returns the text to use, or false if it was rejected
+// * substitute is only called if the text has to be replaced
+func Apply(f Filter, policy Policy, text string, substitute func() string) (string, bool) {
+	if !Contains(f, text) {
+		return text, true
+	}
+	switch policy {
+	case PolicyMask:
+		return Mask(f, text), true
+	case PolicyReplace:
+		return substitute(), true
+	default:
+		return "", false
+	}
+}
+
+// a filter that matches a list of blocked words anywhere in the text, after normalizing both
+type WordList struct {
+	words [][]rune // the normalized blocked words
+}
+
+// create a word list filter from a list of words
+func NewWordList(words []string) *WordList {
+	w := &WordList{}
+	for _, word := range words {
+		if n := normalize(word); len(n.text) > 0 {
+			w.words = append(w.words, n.text)
+		}
+	}
+	return w
+}
+
+// load a word list filter from a file with one word per line; blank lines and lines starting with # are ignored
+func LoadWordList(path string) (*WordList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	words := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) > 0 && !strings.HasPrefix(line, "#") {
+			words = append(words, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read word list %s: %w", path, err)
+	}
+	return NewWordList(words), nil
+}
+
+// returns the number of words in the list
+func (w *WordList) Len() int {
+	return len(w.words)
+}
+
+// find the blocked words in the text; overlapping matches are merged into one span
+func (w *WordList) Find(text string) []Span {
+	n := normalize(text)
+	spans := []Span{}
+	for _, word := range w.words {
+		for i := 0; i+len(word) <= len(n.text); i++ {
+			if slices.Equal(n.text[i:i+len(word)], word) {
+				spans = append(spans, Span{Start: n.origin[i], End: n.origin[i+len(word)-1] + 1})
+			}
+		}
+	}
+	return mergeSpans(spans)
+}
+
+// sort spans and merge those that overlap
+func mergeSpans(spans []Span) []Span {
+	slices.SortFunc(spans, func(a, b Span) int {
+		return a.Start - b.Start
+	})
+	merged := []Span{}
+	for _, span := range spans {
+		if last := len(merged) - 1; last >= 0 && span.Start <= merged[last].End {
+			merged[last].End = max(merged[last].End, span.End)
+			continue
+		}
+		merged = append(merged, span)
+	}
+	return merged
+}
@@ -0,0 +1,77 @@
+package filter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWordListDefeatsObfuscation(t *testing.T) {
+	words := NewWordList([]string{"darn", "heck"})
+	blocked := []string{
+		"darn",
+		"DaRn",
+		"d4rn",
+		"d@rn",
+		"d a.r_n",
+		"ｄａｒｎ",       // fullwidth
+		"d\u0430rn",  // cyrillic a
+		"hèck",       // accented e
+		"h\u200beck", // zero-width space
+		"xXheckXx",
+	}
+	for _, text := range blocked {
+		if !Contains(words, text) {
+			t.Errorf("%q was not matched", text)
+		}
+	}
+	for _, text := range []string{"Spiker", "Dan", "hectic"} {
+		if Contains(words, text) {
+			t.Errorf("%q was matched", text)
+		}
+	}
+}
+
+func TestMaskKeepsOtherText(t *testing.T) {
+	words := NewWordList([]string{"darn", "heck"})
+	if masked := Mask(words, "oh d.a.r.n it, heck!"); masked != "oh ******* it, ****!" {
+		t.Errorf("masked text = %q", masked)
+	}
+	if masked := Mask(words, "ｄａｒｎ"); masked != "****" {
+		t.Errorf("masked text = %q", masked)
+	}
+}
+
+func TestApplyPolicies(t *testing.T) {
+	words := NewWordList([]string{"darn"})
+	guest := func() string { return "Guest0001" }
+	if text, ok := Apply(words, PolicyReject, "Ace", guest); !ok || text != "Ace" {
+		t.Errorf("clean text = %q, %t; want it kept", text, ok)
+	}
+	if _, ok := Apply(words, PolicyReject, "Darn", guest); ok {
+		t.Errorf("blocked text was not rejected")
+	}
+	if text, ok := Apply(words, PolicyMask, "Darn1", guest); !ok || text != "****1" {
+		t.Errorf("masked text = %q, %t", text, ok)
+	}
+	if text, ok := Apply(words, PolicyReplace, "Darn1", guest); !ok || text != "Guest0001" {
+		t.Errorf("replaced text = %q, %t", text, ok)
+	}
+}
+
+func TestLoadWordList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(path, []byte("# blocked words\ndarn\n\n  heck  \n"), 0o644); err != nil {
+		t.Fatalf("Error writing word list: %v", err)
+	}
+	words, err := LoadWordList(path)
+	if err != nil {
+		t.Fatalf("Error loading word list: %v", err)
+	}
+	if words.Len() != 2 || !Contains(words, "HECK") {
+		t.Errorf("loaded %d words; want the 2 listed", words.Len())
+	}
+	if _, err := LoadWordList(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Errorf("loading a missing word list returned no error")
+	}
+}
@@ -0,0 +1,75 @@
+package filter
+
+import (
+	"unicode"
+)
+
+// This file contains the normalization applied to text before it is matched against blocked words
+// * Letters are lowercased, and look-alikes (leetspeak digits and symbols, accented letters, cyrillic and greek homoglyphs, fullwidth forms) are mapped to the plain latin letter they imitate
+// * Separators, punctuation and invisible characters are dropped, so that "b.a d" matches "bad"
+
+// characters that imitate a latin letter
+var lookAlikes = map[rune]rune{
+	// leetspeak
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', '9': 'g',
+	'@': 'a', '$': 's', '!': 'i', '+': 't', '|': 'l', '€': 'e', '£': 'l',
+
+	// accented latin letters
+	'à': 'a', 'á': 'a', 'â': 'a', 'ã': 'a', 'ä': 'a', 'å': 'a', 'ā': 'a',
+	'ç': 'c', 'č': 'c',
+	'è': 'e', 'é': 'e', 'ê': 'e', 'ë': 'e', 'ē': 'e',
+	'ì': 'i', 'í': 'i', 'î': 'i', 'ï': 'i', 'ī': 'i', 'ı': 'i',
+	'ñ': 'n',
+	'ò': 'o', 'ó': 'o', 'ô': 'o', 'õ': 'o', 'ö': 'o', 'ø': 'o', 'ō': 'o',
+	'š': 's', 'ß': 's',
+	'ù': 'u', 'ú': 'u', 'û': 'u', 'ü': 'u', 'ū': 'u',
+	'ý': 'y', 'ÿ': 'y',
+	'ž': 'z',
+
+	// cyrillic homoglyphs
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'з': 'e', 'і': 'i', 'ї': 'i', 'ј': 'j', 'к': 'k', 'м': 'm',
+	'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'ѕ': 's', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w',
+
+	// greek homoglyphs
+	'α': 'a', 'β': 'b', 'γ': 'y', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p',
+	'τ': 't', 'υ': 'u', 'χ': 'x', 'ω': 'w',
+}
+
+// normalized text, along with where each of its characters came from in the original text
+type normalized struct {
+	text   []rune
+	origin []int // the rune offset in the original text of each normalized rune
+}
+
+// normalize text for matching, keeping track of where each normalized character came from
+func normalize(text string) normalized {
+	n := normalized{}
+	i := 0
+	for _, c := range text {
+		if r, ok := normalizeRune(c); ok {
+			n.text = append(n.text, r)
+			n.origin = append(n.origin, i)
+		}
+		i++
+	}
+	return n
+}
+
+// returns the normalized form of a character, or false if it is dropped
+func normalizeRune(c rune) (rune, bool) {
+
+	// fullwidth forms are shifted back to ascii
+	if c >= '！' && c <= '～' {
+		c -= '！' - '!'
+	}
+	c = unicode.ToLower(c)
+	if r, ok := lookAlikes[c]; ok {
+		return r, true
+	}
+
+	// separators, punctuation, symbols, combining marks and invisible characters are dropped
+	if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+		return 0, false
+	}
+	return c, true
+}
@@ -5,6 +5,7 @@ type AddPlayerGameMessage struct {
 	ErrMsg         string `json:"ErrMsg"`
 	ServerPlayerID string `json:"ServerPlayerID"`
 	GameID         string `json:"GameID"`
+	Spectate       bool   `json:"Spectate"` // if true, the player joins as a spectator
 }
 
 // a message that facilitates adding a player with specified server id to the lobby with specified room code
@@ -12,4 +13,7 @@ type AddPlayerLobbyMessage struct {
 	ErrMsg         string `json:"ErrMsg"`
 	ServerPlayerID string `json:"ServerPlayerID"`
 	RoomCode       string `json:"RoomCode"`
+	Spectate       bool   `json:"Spectate"`    // if true, the player joins as a spectator
+	Password       string `json:"Password"`    // the lobby's password, if it has one; never sent back by the server
+	InviteToken    string `json:"InviteToken"` // a one-time invite to the lobby, which may be given instead of its password; never sent back by the server
 }
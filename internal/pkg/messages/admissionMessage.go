@@ -5,8 +5,14 @@ import (
 )
 
 // for initializing a client's data on the server
+// * the server responds with a resume token; if the connection drops, a new connection may send it back in this message to resume the same player
+// * attributes that break the server's attribute policy (stat ranges and budget, display name rules, known accessories) are rejected, with the reason given in ErrMsg
+// * the client may ask for messages to be sent to it in another encoding (e.g. "binary"); the response and every message after it is sent in that encoding
 type AdmissionMessage struct {
+	ErrMsg         string                  `json:"ErrMsg"`
 	ClientPlayerID int                     `json:"ClientPlayerID"`
 	ServerPlayerID string                  `json:"ServerPlayerID"`
+	ResumeToken    string                  `json:"ResumeToken"`
 	Attributes     states.PlayerAttributes `json:"Attributes"`
+	Encoding       string                  `json:"Encoding"`
 }
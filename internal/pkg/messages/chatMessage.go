@@ -0,0 +1,22 @@
+package messages
+
+// a chat message from a player to everyone in their lobby or game
+// * the server fills in the sender's display name and the time it was sent, and masks blocked words, before passing it on
+// * team-only messages are only passed on to the players on the sender's side of the court
+// * players who join a lobby are sent its most recent chat messages
+type ChatMessage struct {
+	ServerPlayerID string `json:"ServerPlayerID"`
+	RoomCode       string `json:"RoomCode"`
+	GameID         string `json:"GameID"`
+	Text           string `json:"Text"`
+	TeamOnly       bool   `json:"TeamOnly"`
+	DisplayName    string `json:"DisplayName"` // filled in by the server
+	SentAt         int64  `json:"SentAt"`      // the time the server received the message (unix milliseconds); filled in by the server
+}
+
+// mute or unmute another player's chat messages for a player
+type MuteMessage struct {
+	ServerPlayerID string `json:"ServerPlayerID"`
+	TargetPlayerID string `json:"TargetPlayerID"`
+	Muted          bool   `json:"Muted"`
+}
@@ -2,6 +2,7 @@ package messages
 
 // a message that checks whether a lobby with the given room code is open
 type CheckLobbyMessage struct {
-	Exists   bool   `json:"Exists"`
-	RoomCode string `json:"RoomCode"`
+	Exists           bool   `json:"Exists"`
+	RoomCode         string `json:"RoomCode"`
+	RequiresPassword bool   `json:"RequiresPassword"` // whether the lobby can only be joined with its password or an invite
 }
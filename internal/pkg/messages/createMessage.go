@@ -1,15 +1,27 @@
 package messages
 
+import (
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/states"
+)
+
 // a request sent by the client to register a new game instance
 // if successful, the response returned by the server will be the guid of the newly registered game
+// the rules of the match may optionally be specified in the request; any that are left unset will use the defaults
+// a snapshot rate may also be requested, in which case player actions and the ball are sent out in batched snapshots at that rate instead of being relayed as they arrive
 type CreateGameMessage struct {
-	GameID string `json:"GameID"`
+	GameID     string           `json:"GameID"`
+	Rules      states.GameRules `json:"Rules"`
+	SnapshotHz int              `json:"SnapshotHz"`
 }
 
 // a request sent by the client to register a new lobby instance
 // if successful, the reponse returned by the server will be the guid of the newly registered lobby, and the room code for display
 // otherwise, the server may either not respond or return an error message
+// a snapshot rate may be requested as for games, which also applies to the matches started from the lobby
+// a lobby is unlisted unless it is created public, in which case it is listed to players browsing for a lobby
 type CreateLobbyMessage struct {
-	ErrMsg   string `json:"ErrMsg"`
-	RoomCode string `json:"RoomCode"`
+	ErrMsg     string `json:"ErrMsg"`
+	RoomCode   string `json:"RoomCode"`
+	SnapshotHz int    `json:"SnapshotHz"`
+	IsPublic   bool   `json:"IsPublic"`
 }
@@ -0,0 +1,11 @@
+package messages
+
+// a predefined emote fired by a player, which clients animate above the player
+// * the code must be in the server's emote catalog; the server fills in its text before passing it on to everyone in the lobby or game
+type EmoteMessage struct {
+	ServerPlayerID string `json:"ServerPlayerID"`
+	RoomCode       string `json:"RoomCode"`
+	GameID         string `json:"GameID"`
+	Code           string `json:"Code"`
+	Text           string `json:"Text"` // filled in by the server
+}
@@ -0,0 +1,30 @@
+package messages
+
+// a message sent back to a client when their request could not be processed
+// * the code tells the client what went wrong so that it can react to it, while the message is meant to be shown to people
+type ErrorMessage struct {
+	Code        string `json:"Code"`
+	ErrMsg      string `json:"ErrMsg"`
+	RequestType string `json:"RequestType"` // the type name of the request that failed
+}
+
+// codes of the errors that a request may fail with
+const (
+	ErrCodeInternal        = "Internal"        // something went wrong on the server
+	ErrCodeBadRequest      = "BadRequest"      // the request could not be decoded, or is missing required information
+	ErrCodeUnknownType     = "UnknownType"     // the type of the request is not supported
+	ErrCodePlayerNotFound  = "PlayerNotFound"  // the player making the request is not registered (e.g. their session expired)
+	ErrCodeRoomNotFound    = "RoomNotFound"    // no lobby exists with the requested room code
+	ErrCodeGameNotFound    = "GameNotFound"    // no game exists with the requested id
+	ErrCodeNotInInstance   = "NotInInstance"   // the player is not in the lobby or game that the request is about
+	ErrCodeLobbyFull       = "LobbyFull"       // both teams of the lobby are full; the player may still spectate
+	ErrCodeGameFull        = "GameFull"        // both teams of the game are full; the player may still spectate
+	ErrCodeNotHost         = "NotHost"         // only the host may make the request
+	ErrCodeSpectator       = "Spectator"       // spectators may not make the request
+	ErrCodeMatchInProgress = "MatchInProgress" // the lobby is already playing a match
+	ErrCodeBallDenied      = "BallDenied"      // the ball update was out of date or broke the rules of play
+	ErrCodeRateLimited     = "RateLimited"     // the player is making the request too often, and should wait before trying again
+	ErrCodeBanned          = "Banned"          // the player was banned from the lobby by its host
+	ErrCodeLobbyLocked     = "LobbyLocked"     // the host has locked the lobby against new joins
+	ErrCodeBadPassword     = "BadPassword"     // the lobby is private, and the password or invite given to join it was missing or wrong
+)
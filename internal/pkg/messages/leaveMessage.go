@@ -7,7 +7,9 @@ type LeaveGameMessage struct {
 }
 
 // a request sent by the client to leave the lobby, if it's nice enough to send one
+// the server broadcasts the same message to everyone in the lobby when a player leaves or is removed
 type LeaveLobbyMessage struct {
 	RoomCode       string `json:"RoomCode"`
 	PlayerServerID string `json:"PlayerServerID"`
+	Reason         string `json:"Reason"` // why the server removed the player (e.g. they were kicked by the host); empty if they left on their own
 }
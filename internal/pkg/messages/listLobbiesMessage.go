@@ -0,0 +1,28 @@
+package messages
+
+// a request for a page of the public lobbies on the server, to let players find a lobby without being given its room code
+// the server replies with the same message, with the filters that were applied and the listings filled in
+// * lobbies are listed with the most players first, and those locked by their host are left out since no one can join them
+type ListLobbiesMessage struct {
+	Page        int    `json:"Page"`        // the zero-based page to list
+	PageSize    int    `json:"PageSize"`    // the number of lobbies per page; zero for the server's default
+	Backdrop    string `json:"Backdrop"`    // if non-empty, only lobbies with this backdrop are listed
+	HideFull    bool   `json:"HideFull"`    // if true, lobbies with no open slot on the court are left out
+	HideInMatch bool   `json:"HideInMatch"` // if true, lobbies that are playing a match are left out
+	HidePrivate bool   `json:"HidePrivate"` // if true, lobbies that need a password to join are left out
+
+	Lobbies []LobbyListing `json:"Lobbies"` // set by the server in its reply
+	Total   int            `json:"Total"`   // the number of lobbies on all pages; set by the server in its reply
+}
+
+// a public lobby as listed to players browsing for one
+type LobbyListing struct {
+	RoomCode         string `json:"RoomCode"`
+	HostDisplayName  string `json:"HostDisplayName"` // empty if the lobby has no host at the moment
+	Backdrop         string `json:"Backdrop"`
+	NumPlayers       int    `json:"NumPlayers"`
+	MaxPlayers       int    `json:"MaxPlayers"`
+	NumSpectators    int    `json:"NumSpectators"`
+	InMatch          bool   `json:"InMatch"`
+	RequiresPassword bool   `json:"RequiresPassword"`
+}
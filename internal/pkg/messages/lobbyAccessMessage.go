@@ -0,0 +1,18 @@
+package messages
+
+// a request sent by the host to set or remove the password of their lobby
+// the server replies to the host with the password left out, once it has been applied
+type SetLobbyPasswordMessage struct {
+	ServerPlayerID   string `json:"ServerPlayerID"` // the id of the host making the request
+	RoomCode         string `json:"RoomCode"`
+	Password         string `json:"Password"`         // the new password; empty to let anyone with the room code join again
+	RequiresPassword bool   `json:"RequiresPassword"` // set by the server in its reply
+}
+
+// a request sent by the host for a one-time invite to their lobby, which lets a player join without the password
+// the server replies to the host with the invite token, which is theirs to pass on
+type CreateInviteMessage struct {
+	ServerPlayerID string `json:"ServerPlayerID"` // the id of the host making the request
+	RoomCode       string `json:"RoomCode"`
+	InviteToken    string `json:"InviteToken"` // set by the server in its reply
+}
@@ -0,0 +1,22 @@
+package messages
+
+import (
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/states"
+)
+
+// a message from the server that communicates that the lobby's match has started, with the id of the newly created game
+// * matches are started by the server once enough players are ready (see ReadyMessage)
+type StartMatchMessage struct {
+	ServerPlayerID string           `json:"ServerPlayerID"` // the id of the host of the lobby
+	RoomCode       string           `json:"RoomCode"`
+	GameID         string           `json:"GameID"` // the id of the game that the lobby was moved into
+	Rules          states.GameRules `json:"Rules"`  // the rules of the match; any that are left unset will use the defaults
+}
+
+// a request sent by the host of a game to bring all of its players back to the lobby it was started from
+// the server broadcasts the same message to everyone in the lobby once they have been moved back
+type ReturnLobbyMessage struct {
+	ServerPlayerID string `json:"ServerPlayerID"` // the id of the host making the request
+	GameID         string `json:"GameID"`
+	RoomCode       string `json:"RoomCode"`
+}
@@ -4,6 +4,7 @@ import (
 	"strconv"
 	"testing"
 
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/states"
 	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/structures"
 )
 
@@ -52,3 +53,133 @@ func TestSerializeCreateLobbyRequest(t *testing.T) {
 	}
 	structures.CompareSerializeDeserialize(t, rq, func(rq CreateLobbyMessage) string { return rq.ErrMsg + rq.RoomCode })
 }
+
+func TestSerializeScoreMessage(t *testing.T) {
+	rq := ScoreMessage{
+		GameID: "xyzguid",
+	}
+	rq.Score.Points[1] = 7
+	structures.CompareSerializeDeserialize(t, rq, func(rq ScoreMessage) string { return rq.GameID + strconv.Itoa(rq.Score.Points[1]) })
+}
+
+// round trip a message through every supported codec
+func roundTrip[T any](t *testing.T, original T) {
+	for _, codec := range []structures.Codec{structures.JSONCodec, structures.BinaryCodec} {
+		structures.CompareCodecRoundTrip(t, codec, original)
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	action := states.PlayerAction{
+		Pos:       structures.Vector2{X: -3.5, Y: 1.25},
+		Vel:       structures.Vector2{X: 2, Y: -9.81},
+		FaceRight: true,
+		Anim:      "jump",
+		AxisX:     -1,
+	}
+	attributes := states.PlayerAttributes{
+		DisplayName: "anyString",
+		Influence:   -12,
+		Strength:    0.75,
+		Hair:        "hair_03",
+	}
+	rules := states.GameRules{
+		PointsPerSet:      21,
+		WinBy:             2,
+		BestOf:            5,
+		DisableTouchRules: true,
+		MaxRewindMs:       150,
+	}
+	score := states.MatchScore{
+		Points:      [2]int{24, 23},
+		SetsWon:     [2]int{1, 0},
+		SetNumber:   2,
+		ServingTeam: states.TeamRight,
+		RallyCount:  300,
+	}
+	ball := states.BallState{
+		Pos:          structures.Vector2{X: 4, Y: 6},
+		Vel:          structures.Vector2{X: -8, Y: 0.5},
+		GravityScale: 0.6,
+		TouchedBy:    "anyString",
+		TouchCount:   2,
+		LiveState:    states.BallLiveStateAlive,
+	}
+	ball.GUID = "xyzguid"
+
+	roundTrip(t, PingMessage{PingTime: "anyString"})
+	roundTrip(t, PingMessage{ClientSendTime: 1700000000123, ServerRecvTime: 1700000000456, ServerSendTime: 1700000000457, ServerPlayerID: "anyString", PrevServerSendTime: 1700000000001, PrevClientRecvTime: 1700000000100, RTTMs: 42.5, JitterMs: 3.25})
+	roundTrip(t, AdmissionMessage{ClientPlayerID: 42, ServerPlayerID: "anyString", ResumeToken: "abc123", Attributes: attributes, Encoding: structures.CodecNameBinary})
+	roundTrip(t, AddPlayerGameMessage{ServerPlayerID: "anyString", GameID: "xyzguid", Spectate: true})
+	roundTrip(t, AddPlayerLobbyMessage{ErrMsg: "full", ServerPlayerID: "anyString", RoomCode: "QBPX"})
+	roundTrip(t, AddPlayerLobbyMessage{ServerPlayerID: "anyString", RoomCode: "QBPX", Password: "spike", InviteToken: "0123456789abcdef"})
+	roundTrip(t, BallStateMessage{Ball: ball, GameID: "xyzguid"})
+	roundTrip(t, CheckLobbyMessage{Exists: true, RoomCode: "QBPX"})
+	roundTrip(t, CheckLobbyMessage{Exists: true, RoomCode: "QBPX", RequiresPassword: true})
+	roundTrip(t, CreateGameMessage{GameID: "xyzguid", Rules: rules})
+	roundTrip(t, CreateLobbyMessage{ErrMsg: "", RoomCode: "JXPQ"})
+	roundTrip(t, CreateLobbyMessage{RoomCode: "JXPQ", SnapshotHz: 20, IsPublic: true})
+	roundTrip(t, ForcePlayerMessage{Action: action, ServerPlayerID: "anyString"})
+	roundTrip(t, LeaveGameMessage{GameID: "xyzguid", PlayerServerID: "anyString"})
+	roundTrip(t, LeaveLobbyMessage{RoomCode: "QBPX", PlayerServerID: "anyString"})
+	roundTrip(t, LeaveLobbyMessage{RoomCode: "QBPX", PlayerServerID: "anyString", Reason: "Kicked by the host"})
+	roundTrip(t, StartMatchMessage{ServerPlayerID: "anyString", RoomCode: "QBPX", GameID: "xyzguid", Rules: rules})
+	roundTrip(t, ReturnLobbyMessage{ServerPlayerID: "anyString", GameID: "xyzguid", RoomCode: "QBPX"})
+	roundTrip(t, PlayerActionMessage{Action: action, PlayerServerID: "anyString", RoomCode: "QBPX"})
+	roundTrip(t, PlayerIncludeMessage{Attributes: attributes, Action: action, ServerPlayerID: "anyString", RTTMs: 64.5})
+	roundTrip(t, PromoteSpectatorMessage{ServerPlayerID: "anyString", TargetPlayerID: "otherString", RoomCode: "QBPX"})
+	roundTrip(t, ReadyMessage{ServerPlayerID: "anyString", RoomCode: "QBPX", IsReady: true})
+	roundTrip(t, ReadyRosterMessage{RoomCode: "QBPX", ReadyPlayerIDs: []string{"anyString", "otherString"}, RequiredReady: 3, NumPlayers: 4})
+	roundTrip(t, CountdownMessage{RoomCode: "QBPX", SecondsLeft: 5, Cancelled: true})
+	roundTrip(t, MatchSettingsMessage{ServerPlayerID: "anyString", RoomCode: "QBPX", ReadyQuorum: 2, Rules: rules})
+	roundTrip(t, ScoreMessage{Score: score, GameID: "xyzguid"})
+	roundTrip(t, MatchEndMessage{WinningTeam: states.TeamLeft, Score: score, GameID: "xyzguid"})
+	roundTrip(t, SetBackdropMessage{RoomCode: "QBPX", ResourceName: "beach"})
+	roundTrip(t, SwitchSideMessage{ServerPlayerID: "anyString", RoomCode: "QBPX"})
+	roundTrip(t, SyncHostMessage{HostID: "anyString"})
+	roundTrip(t, SnapshotMessage{Tick: 9000, GameID: "xyzguid", Players: []PlayerSnapshot{{ServerPlayerID: "anyString", Action: action}}, Ball: &ball})
+	roundTrip(t, SnapshotMessage{Tick: 1, RoomCode: "QBPX", Players: []PlayerSnapshot{{ServerPlayerID: "anyString"}, {ServerPlayerID: "otherString", Action: action}}})
+	axisX, anim := float32(-1), "run"
+	roundTrip(t, SnapshotDeltaMessage{Tick: 12, BaselineTick: 10, GameID: "xyzguid", Players: []PlayerDelta{{ServerPlayerID: "anyString", AxisX: &axisX, Anim: &anim}}, Removed: []string{"otherString"}, Ball: &ball})
+	roundTrip(t, SnapshotAckMessage{GameID: "xyzguid", Tick: 12})
+	roundTrip(t, ChatMessage{ServerPlayerID: "anyString", RoomCode: "QBPX", Text: "nice spike!", TeamOnly: true, DisplayName: "Ace", SentAt: 1700000000123})
+	roundTrip(t, EmoteMessage{ServerPlayerID: "anyString", GameID: "xyzguid", Code: "nice", Text: "nice!"})
+	roundTrip(t, MuteMessage{ServerPlayerID: "anyString", TargetPlayerID: "otherString", Muted: true})
+	roundTrip(t, KickPlayerMessage{ServerPlayerID: "anyString", TargetPlayerID: "otherString", RoomCode: "QBPX", Reason: "afk", Ban: true})
+	roundTrip(t, TransferHostMessage{ServerPlayerID: "anyString", TargetPlayerID: "otherString", RoomCode: "QBPX"})
+	roundTrip(t, ListLobbiesMessage{Page: 1, PageSize: 10, Backdrop: "beach", HideFull: true, HideInMatch: true, HidePrivate: true, Total: 11, Lobbies: []LobbyListing{{RoomCode: "QBPX", HostDisplayName: "Ace", Backdrop: "beach", NumPlayers: 3, MaxPlayers: 12, NumSpectators: 1, InMatch: true, RequiresPassword: true}}})
+	roundTrip(t, SetLobbyPasswordMessage{ServerPlayerID: "anyString", RoomCode: "QBPX", Password: "spike", RequiresPassword: true})
+	roundTrip(t, CreateInviteMessage{ServerPlayerID: "anyString", RoomCode: "QBPX", InviteToken: "0123456789abcdef"})
+	roundTrip(t, LockLobbyMessage{ServerPlayerID: "anyString", RoomCode: "QBPX", Locked: true})
+	roundTrip(t, ErrorMessage{Code: ErrCodeLobbyFull, ErrMsg: "lobby QBPX is full", RequestType: "messages.AddPlayerLobbyMessage"})
+}
+
+func TestBinaryCodecIsSmaller(t *testing.T) {
+	msg := PlayerActionMessage{
+		Action:         states.PlayerAction{Pos: structures.Vector2{X: 1, Y: 2}, Anim: "run"},
+		PlayerServerID: "5f0c8a8e-3f0b-4a57-a6a3-6e1fe4b8f1c2",
+		GameID:         "8e6a7b70-2b53-4d3c-9e8e-b3c1f1f6d6a4",
+	}
+	text, err := structures.JSONCodec.Encode(msg, "")
+	if err != nil {
+		t.Fatalf("Error encoding with json codec: %v", err)
+	}
+	bin, err := structures.BinaryCodec.Encode(msg, "")
+	if err != nil {
+		t.Fatalf("Error encoding with binary codec: %v", err)
+	}
+	if len(bin) >= len(text) {
+		t.Errorf("Binary encoding is %d bytes; want fewer than the %d bytes of json", len(bin), len(text))
+	}
+}
+
+func TestBinaryCodecRejectsWrongType(t *testing.T) {
+	msg, err := structures.BinaryCodec.Encode(PingMessage{PingTime: "anyString"}, "")
+	if err != nil {
+		t.Fatalf("Error encoding: %v", err)
+	}
+	var rq SyncHostMessage
+	if err := structures.BinaryCodec.Decode(&rq, msg); err == nil {
+		t.Errorf("Decoding a PingMessage into a SyncHostMessage succeeded; want an error")
+	}
+}
@@ -0,0 +1,19 @@
+package messages
+
+// a request sent by the host to remove a player from their lobby, optionally banning them from rejoining it
+// the kicked player is told why through the LeaveLobbyMessage that the server broadcasts when they are removed
+type KickPlayerMessage struct {
+	ServerPlayerID string `json:"ServerPlayerID"` // the id of the host making the request
+	TargetPlayerID string `json:"TargetPlayerID"` // the id of the player to remove
+	RoomCode       string `json:"RoomCode"`       // the lobby to remove them from
+	Reason         string `json:"Reason"`         // shown to the kicked player; may be empty
+	Ban            bool   `json:"Ban"`            // whether the player is kept from rejoining the lobby for as long as it exists
+}
+
+// a request sent by the host to lock or unlock their lobby against new joins
+// the server broadcasts the same message to everyone in the lobby once it has been applied
+type LockLobbyMessage struct {
+	ServerPlayerID string `json:"ServerPlayerID"` // the id of the host making the request
+	RoomCode       string `json:"RoomCode"`
+	Locked         bool   `json:"Locked"`
+}
@@ -1,6 +1,17 @@
 package messages
 
-// represents a game ball, along with all of its state variables
+// a clock synchronization exchange between a client and the server, in the style of NTP
+// * the client sends the time it sent the ping; the server fills in the times it received and replied to it (unix milliseconds)
+// * from the reply the client may estimate its round trip time as (clientRecv - ClientSendTime) - (ServerSendTime - ServerRecvTime), and its clock offset from the server as ((ServerRecvTime - ClientSendTime) + (ServerSendTime - clientRecv)) / 2
+// * a client that pings on behalf of a player echoes the server send time of the previous reply along with the time it received it, so that the server can measure the player's round trip time itself
 type PingMessage struct {
-	PingTime string `json:"PingTime"`
+	PingTime           string  `json:"PingTime"`           // an opaque value echoed back as is; kept for older clients
+	ClientSendTime     int64   `json:"ClientSendTime"`     // the time the client sent the ping, on the client's clock
+	ServerRecvTime     int64   `json:"ServerRecvTime"`     // the time the server received the ping, on the server's clock; filled in by the server
+	ServerSendTime     int64   `json:"ServerSendTime"`     // the time the server replied to the ping, on the server's clock; filled in by the server
+	ServerPlayerID     string  `json:"ServerPlayerID"`     // the player whose round trip time is measured, if any
+	PrevServerSendTime int64   `json:"PrevServerSendTime"` // the ServerSendTime of the previous reply received by the client; zero if none
+	PrevClientRecvTime int64   `json:"PrevClientRecvTime"` // the time the client received the previous reply, on the client's clock; zero if none
+	RTTMs              float64 `json:"RTTMs"`              // the server's smoothed estimate of the player's round trip time in milliseconds; filled in by the server
+	JitterMs           float64 `json:"JitterMs"`           // the server's smoothed estimate of the variation in the player's round trip time in milliseconds; filled in by the server
 }
@@ -9,4 +9,5 @@ type PlayerIncludeMessage struct {
 	Attributes     states.PlayerAttributes `json:"Attributes"`
 	Action         states.PlayerAction     `json:"Action"`
 	ServerPlayerID string                  `json:"ServerPlayerID"`
+	RTTMs          float64                 `json:"RTTMs"` // the server's smoothed estimate of the player's round trip time in milliseconds; zero if not measured yet
 }
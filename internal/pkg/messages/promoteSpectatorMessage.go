@@ -0,0 +1,10 @@
+package messages
+
+// a request sent by the host to promote a spectator to a player in their game or lobby
+// the server broadcasts the same message to everyone in the instance once the spectator has been promoted
+type PromoteSpectatorMessage struct {
+	ServerPlayerID string `json:"ServerPlayerID"` // the id of the host making the request
+	TargetPlayerID string `json:"TargetPlayerID"` // the id of the spectator to promote
+	GameID         string `json:"GameID"`         // if non-empty, the game that the spectator is in
+	RoomCode       string `json:"RoomCode"`       // if non-empty, the lobby that the spectator is in
+}
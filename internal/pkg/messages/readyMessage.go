@@ -0,0 +1,35 @@
+package messages
+
+import (
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/states"
+)
+
+// a request sent by a player in a lobby to mark themselves as ready (or no longer ready) to start a match
+type ReadyMessage struct {
+	ServerPlayerID string `json:"ServerPlayerID"`
+	RoomCode       string `json:"RoomCode"`
+	IsReady        bool   `json:"IsReady"`
+}
+
+// a message from the server listing the players in a lobby that are ready to start a match
+type ReadyRosterMessage struct {
+	RoomCode       string   `json:"RoomCode"`
+	ReadyPlayerIDs []string `json:"ReadyPlayerIDs"` // the ids of the players who are ready
+	RequiredReady  int      `json:"RequiredReady"`  // the number of ready players needed to start the match
+	NumPlayers     int      `json:"NumPlayers"`     // the number of players in the lobby
+}
+
+// a message from the server counting down to the start of a match, or communicating that the countdown was cancelled
+type CountdownMessage struct {
+	RoomCode    string `json:"RoomCode"`
+	SecondsLeft int    `json:"SecondsLeft"`
+	Cancelled   bool   `json:"Cancelled"`
+}
+
+// a request sent by the host of a lobby to configure the next match; the server broadcasts the same message to everyone in the lobby
+type MatchSettingsMessage struct {
+	ServerPlayerID string           `json:"ServerPlayerID"` // the id of the host making the request
+	RoomCode       string           `json:"RoomCode"`
+	ReadyQuorum    int              `json:"ReadyQuorum"` // the number of ready players needed to start the match; zero means everyone
+	Rules          states.GameRules `json:"Rules"`       // the rules of the match; any that are left unset will use the defaults
+}
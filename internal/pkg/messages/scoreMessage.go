@@ -0,0 +1,18 @@
+package messages
+
+import (
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/states"
+)
+
+// a message from the server that communicates the score of the match in the game with specified id
+type ScoreMessage struct {
+	Score  states.MatchScore `json:"Score"`
+	GameID string            `json:"GameID"`
+}
+
+// a message from the server that communicates that the match in the game with specified id has ended
+type MatchEndMessage struct {
+	WinningTeam int               `json:"WinningTeam"` // the team index of the winner (0 = left, 1 = right)
+	Score       states.MatchScore `json:"Score"`
+	GameID      string            `json:"GameID"`
+}
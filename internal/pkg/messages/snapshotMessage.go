@@ -0,0 +1,72 @@
+package messages
+
+import (
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/states"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/structures"
+)
+
+// a batched update of every player in a game or lobby, along with the game ball, sent on a fixed tick
+// * only sent to instances that were created with a snapshot rate; player actions are not relayed individually to those instances
+// * once a client acknowledges a snapshot, it is sent a SnapshotDeltaMessage against it instead, until it stops acknowledging them
+type SnapshotMessage struct {
+	Tick     uint64            `json:"Tick"`     // the number of the snapshot, counting up from 1 for each instance
+	GameID   string            `json:"GameID"`   // if non-empty, the game that the snapshot is of
+	RoomCode string            `json:"RoomCode"` // if non-empty, the lobby that the snapshot is of
+	Players  []PlayerSnapshot  `json:"Players"`
+	Ball     *states.BallState `json:"Ball"` // the game ball, or nil if there is none in play
+}
+
+// the latest action of a single player in a snapshot
+type PlayerSnapshot struct {
+	ServerPlayerID string              `json:"ServerPlayerID"`
+	Action         states.PlayerAction `json:"Action"`
+}
+
+// a snapshot that only contains what changed since an earlier snapshot (the baseline) that the client acknowledged
+// * players that did not change since the baseline are left out, and players that left since the baseline are listed by id
+type SnapshotDeltaMessage struct {
+	Tick         uint64            `json:"Tick"`
+	BaselineTick uint64            `json:"BaselineTick"` // the tick of the snapshot that the changes are relative to
+	GameID       string            `json:"GameID"`
+	RoomCode     string            `json:"RoomCode"`
+	Players      []PlayerDelta     `json:"Players"`
+	Removed      []string          `json:"Removed"` // the ids of the players who were in the baseline but are no longer in the instance
+	Ball         *states.BallState `json:"Ball"`    // the game ball, or nil if there is none in play; always sent in full since it moves every tick
+}
+
+// the fields of a player's action that changed since the baseline; unchanged fields are nil
+// * a player who joined since the baseline has every field set
+type PlayerDelta struct {
+	ServerPlayerID string              `json:"ServerPlayerID"`
+	Pos            *structures.Vector2 `json:"Pos,omitempty"`
+	Vel            *structures.Vector2 `json:"Vel,omitempty"`
+	FaceRight      *bool               `json:"FaceRight,omitempty"`
+	Anim           *string             `json:"Anim,omitempty"`
+	AxisX          *float32            `json:"AxisX,omitempty"`
+}
+
+// apply the changes to a player's action from the baseline
+func (d *PlayerDelta) Apply(a *states.PlayerAction) {
+	if d.Pos != nil {
+		a.Pos = *d.Pos
+	}
+	if d.Vel != nil {
+		a.Vel = *d.Vel
+	}
+	if d.FaceRight != nil {
+		a.FaceRight = *d.FaceRight
+	}
+	if d.Anim != nil {
+		a.Anim = *d.Anim
+	}
+	if d.AxisX != nil {
+		a.AxisX = *d.AxisX
+	}
+}
+
+// a message from a client acknowledging that it received a snapshot, so that later snapshots can be sent as changes against it
+type SnapshotAckMessage struct {
+	GameID   string `json:"GameID"`   // if non-empty, the game that the snapshot was of
+	RoomCode string `json:"RoomCode"` // if non-empty, the lobby that the snapshot was of
+	Tick     uint64 `json:"Tick"`
+}
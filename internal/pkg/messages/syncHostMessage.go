@@ -4,3 +4,12 @@ package messages
 type SyncHostMessage struct {
 	HostID string `json:"HostID"`
 }
+
+// a request sent by the host to hand off host status to another player in their game or lobby
+// the server broadcasts a SyncHostMessage to everyone in the instance once the host has changed
+type TransferHostMessage struct {
+	ServerPlayerID string `json:"ServerPlayerID"` // the id of the host making the request
+	TargetPlayerID string `json:"TargetPlayerID"` // the id of the player to make host
+	GameID         string `json:"GameID"`         // if non-empty, the game to transfer host status in
+	RoomCode       string `json:"RoomCode"`       // if non-empty, the lobby to transfer host status in
+}
@@ -0,0 +1,113 @@
+package states
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
+)
+
+// the limits that a player's attributes must be within for them to be admitted to the server
+type AttributePolicy struct {
+	MinStat       float32 // the lowest level of each stat
+	MaxStat       float32 // the highest level of each stat
+	StatBudget    float32 // the maximum sum of the Strength, Speed, Jump and Size levels
+	MaxNameLength int     // the maximum number of characters in a display name
+
+	// allow-lists of the visual accessory codes; an empty list allows any well-formed code, and an empty code (no accessory) is always allowed
+	Emblems     []string
+	Hairs       []string
+	LowerFaces  []string
+	Expressions []string
+}
+
+// returns the policy used unless the server is configured otherwise
+func DefaultAttributePolicy() AttributePolicy {
+	return AttributePolicy{
+		MinStat:       defs.MinStatLevel,
+		MaxStat:       defs.MaxStatLevel,
+		StatBudget:    defs.StatBudget,
+		MaxNameLength: defs.MaxDisplayNameLength,
+		Emblems:       accessoryCodes("emblem", defs.NumEmblems),
+		Hairs:         accessoryCodes("hair", defs.NumHairs),
+		LowerFaces:    accessoryCodes("lowerface", defs.NumLowerFaces),
+		Expressions:   accessoryCodes("expression", defs.NumExpressions),
+	}
+}
+
+// returns the codes of the given number of accessories of a kind, numbered from 1 (e.g. hair_01, hair_02, ...)
+func accessoryCodes(prefix string, n int) []string {
+	codes := make([]string, 0, n)
+	for i := 1; i <= n; i++ {
+		codes = append(codes, fmt.Sprintf("%s_%02d", prefix, i))
+	}
+	return codes
+}
+
+// check a player's attributes against the policy
+// * returns the attributes to admit the player with (e.g. with the whitespace of their name tidied up), or an error describing the first problem found
+func (p AttributePolicy) Validate(attrs PlayerAttributes) (PlayerAttributes, error) {
+
+	// each stat must be within range, and all of them within the budget
+	stats := []struct {
+		name  string
+		value float32
+	}{{"Strength", attrs.Strength}, {"Speed", attrs.Speed}, {"Jump", attrs.Jump}, {"Size", attrs.Size}}
+	var sum float32
+	for _, stat := range stats {
+		if math.IsNaN(float64(stat.value)) || stat.value < p.MinStat || stat.value > p.MaxStat {
+			return attrs, fmt.Errorf("%s %g is outside the allowed range of %g to %g", stat.name, stat.value, p.MinStat, p.MaxStat)
+		}
+		sum += stat.value
+	}
+	if sum > p.StatBudget {
+		return attrs, fmt.Errorf("stats add up to %g, which is over the budget of %g", sum, p.StatBudget)
+	}
+
+	// the display name must be short and made of ordinary characters
+	attrs.DisplayName = strings.Join(strings.Fields(attrs.DisplayName), " ")
+	if len(attrs.DisplayName) == 0 {
+		return attrs, fmt.Errorf("display name is empty")
+	}
+	if n := utf8.RuneCountInString(attrs.DisplayName); n > p.MaxNameLength {
+		return attrs, fmt.Errorf("display name is %d characters long, over the limit of %d", n, p.MaxNameLength)
+	}
+	for _, c := range attrs.DisplayName {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && !strings.ContainsRune(" _-.'", c) {
+			return attrs, fmt.Errorf("display name contains the character %q, which is not allowed", c)
+		}
+	}
+
+	// the accessories must be ones that the game knows about
+	accessories := []struct {
+		name    string
+		code    string
+		allowed []string
+	}{{"Emblem", attrs.Emblem, p.Emblems}, {"Hair", attrs.Hair, p.Hairs}, {"LowerFace", attrs.LowerFace, p.LowerFaces}, {"Expression", attrs.Expression, p.Expressions}}
+	for _, accessory := range accessories {
+		if len(accessory.code) == 0 {
+			continue
+		}
+		if !isAccessoryCode(accessory.code) || (len(accessory.allowed) > 0 && !slices.Contains(accessory.allowed, accessory.code)) {
+			return attrs, fmt.Errorf("%s %q is not a known accessory", accessory.name, accessory.code)
+		}
+	}
+	return attrs, nil
+}
+
+// returns whether a code is well-formed: short, and made of lowercase letters, digits and underscores
+func isAccessoryCode(code string) bool {
+	if len(code) > defs.MaxAccessoryCodeLength {
+		return false
+	}
+	for _, c := range code {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '_' {
+			return false
+		}
+	}
+	return true
+}
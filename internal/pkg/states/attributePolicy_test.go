@@ -0,0 +1,70 @@
+package states
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAttributePolicy(t *testing.T) {
+	policy := DefaultAttributePolicy()
+	policy.Hairs = []string{"hair_01", "hair_03"}
+	valid := PlayerAttributes{DisplayName: "  Spike   Master ", Strength: 5, Speed: 5, Jump: 5, Size: 5, Hair: "hair_03", Emblem: "emblem_07"}
+
+	attrs, err := policy.Validate(valid)
+	if err != nil {
+		t.Fatalf("valid attributes were rejected: %v", err)
+	}
+	if attrs.DisplayName != "Spike Master" {
+		t.Errorf("display name = %q; want its whitespace tidied up", attrs.DisplayName)
+	}
+
+	// each of these breaks one rule of the policy
+	invalid := map[string]func(a *PlayerAttributes){
+		"stat out of range": func(a *PlayerAttributes) { a.Strength = 9999 },
+		"negative stat":     func(a *PlayerAttributes) { a.Size = -1 },
+		"over budget":       func(a *PlayerAttributes) { a.Speed = 6 },
+		"empty name":        func(a *PlayerAttributes) { a.DisplayName = "   " },
+		"long name":         func(a *PlayerAttributes) { a.DisplayName = strings.Repeat("a", 10240) },
+		"bad characters":    func(a *PlayerAttributes) { a.DisplayName = "<b>hi</b>" },
+		"unlisted hair":     func(a *PlayerAttributes) { a.Hair = "hair_99" },
+		"malformed emblem":  func(a *PlayerAttributes) { a.Emblem = "../../etc/passwd" },
+		"unlisted emblem":   func(a *PlayerAttributes) { a.Emblem = "emblem_99" },
+	}
+	for name, breakRule := range invalid {
+		attrs := valid
+		breakRule(&attrs)
+		if _, err := policy.Validate(attrs); err == nil {
+			t.Errorf("attributes with %s were accepted", name)
+		}
+	}
+}
+
+func TestDefaultAttributePolicyAccessories(t *testing.T) {
+	policy := DefaultAttributePolicy()
+	base := PlayerAttributes{DisplayName: "Ace", Strength: 5, Speed: 5, Jump: 5, Size: 5}
+
+	// no accessories, and the first and last of each kind, are accepted
+	if _, err := policy.Validate(base); err != nil {
+		t.Errorf("attributes without accessories were rejected: %v", err)
+	}
+	listed := base
+	listed.Emblem, listed.Hair, listed.LowerFace, listed.Expression = "emblem_01", "hair_16", "lowerface_08", "expression_01"
+	if _, err := policy.Validate(listed); err != nil {
+		t.Errorf("attributes with listed accessories were rejected: %v", err)
+	}
+
+	// well-formed codes that aren't listed are rejected
+	unlisted := map[string]func(a *PlayerAttributes){
+		"Emblem":     func(a *PlayerAttributes) { a.Emblem = "emblem_25" },
+		"Hair":       func(a *PlayerAttributes) { a.Hair = "hair_1" },
+		"LowerFace":  func(a *PlayerAttributes) { a.LowerFace = "lowerface_00" },
+		"Expression": func(a *PlayerAttributes) { a.Expression = "smirk" },
+	}
+	for name, setCode := range unlisted {
+		attrs := listed
+		setCode(&attrs)
+		if _, err := policy.Validate(attrs); err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("unlisted %s was not rejected with an error naming it: %v", name, err)
+		}
+	}
+}
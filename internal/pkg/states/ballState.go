@@ -3,6 +3,7 @@ package states
 import (
 	"strings"
 
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
 	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/structures"
 )
 
@@ -21,6 +22,20 @@ type BallState struct {
 	ServeState   string             `json:"ServeState"`   // the service status code of the ball
 }
 
+// live state codes of the ball
+const (
+	BallLiveStateAlive = "Alive"
+	BallLiveStateDead  = "Dead"
+)
+
+// the outcome of advancing the ball by one simulation step
+type BallStepResult struct {
+	CrossedNet  bool // the ball passed over the net to the other side
+	HitNet      bool // the ball made contact with the net and bounced back
+	Landed      bool // the ball hit the floor and is now dead
+	OutOfBounds bool // the ball landed beyond the back line of the court
+}
+
 // returns whether the ball's `LiveState“ indicates that it is alive
 func (b *BallState) IsAlive() bool {
 	return strings.Contains(strings.ToLower(b.LiveState), "alive")
@@ -39,3 +54,43 @@ func (b *BallState) Clone() *BallState {
 		ServeState:   b.ServeState,
 	}
 }
+
+// advance the ball by a time step of `dt` seconds, using the same gravity model as the client, and resolve any contact with the net or floor
+func (b *BallState) Step(dt float32) BallStepResult {
+	result := BallStepResult{}
+
+	// integrate velocity then position
+	prevX := b.Pos.X
+	b.Vel.Y += defs.Gravity * b.GravityScale * dt
+	b.Pos.X += b.Vel.X * dt
+	b.Pos.Y += b.Vel.Y * dt
+
+	// check whether the ball went past the plane of the net during this step
+	wasRight := prevX > defs.NetPosX
+	isRight := b.Pos.X > defs.NetPosX
+	if wasRight != isRight {
+		if b.Pos.Y-defs.BallRadius < defs.NetHeight {
+
+			// the ball is below the top of the net, so bounce it back to the side it came from
+			sideSign := float32(-1)
+			if wasRight {
+				sideSign = 1
+			}
+			b.Pos.X = defs.NetPosX + sideSign*defs.BallRadius
+			b.Vel.X = -b.Vel.X * defs.NetRestitution
+			result.HitNet = true
+		} else {
+			result.CrossedNet = true
+		}
+	}
+
+	// check whether the ball has landed on the floor
+	if b.Pos.Y-defs.BallRadius <= defs.FloorY {
+		b.Pos.Y = defs.FloorY + defs.BallRadius
+		b.Vel = structures.Vector2{}
+		b.LiveState = BallLiveStateDead
+		result.Landed = true
+		result.OutOfBounds = b.Pos.X > defs.CourtHalfLength || b.Pos.X < -defs.CourtHalfLength
+	}
+	return result
+}
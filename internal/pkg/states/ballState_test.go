@@ -0,0 +1,111 @@
+package states
+
+import (
+	"testing"
+
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/structures"
+)
+
+// simulate a ball until it lands or the step limit is reached, and return the accumulated results
+func simulateUntilLanded(b *BallState, maxSteps int) BallStepResult {
+	dt := float32(1.0 / float64(defs.BallTickRate))
+	total := BallStepResult{}
+	for i := 0; i < maxSteps; i++ {
+		r := b.Step(dt)
+		total.CrossedNet = total.CrossedNet || r.CrossedNet
+		total.HitNet = total.HitNet || r.HitNet
+		if r.Landed {
+			total.Landed = true
+			total.OutOfBounds = r.OutOfBounds
+			break
+		}
+	}
+	return total
+}
+
+func TestBallLandsInCourt(t *testing.T) {
+	b := &BallState{
+		Pos:          structures.Vector2{X: -5, Y: 6},
+		Vel:          structures.Vector2{X: 8, Y: 2},
+		GravityScale: 1,
+		LiveState:    BallLiveStateAlive,
+	}
+	r := simulateUntilLanded(b, 1000)
+	if !r.Landed || r.OutOfBounds || !r.CrossedNet {
+		t.Errorf("ball step result = %+v; want landed in court after crossing the net", r)
+	}
+	if b.IsAlive() || b.Pos.X <= defs.NetPosX {
+		t.Errorf("ball landed at x=%.2f alive=%t; want dead on the right side", b.Pos.X, b.IsAlive())
+	}
+}
+
+func TestBallHitsNet(t *testing.T) {
+	b := &BallState{
+		Pos:          structures.Vector2{X: -2, Y: 1.5},
+		Vel:          structures.Vector2{X: 6, Y: 0},
+		GravityScale: 1,
+		LiveState:    BallLiveStateAlive,
+	}
+	r := simulateUntilLanded(b, 1000)
+	if !r.HitNet || r.CrossedNet {
+		t.Errorf("ball step result = %+v; want net contact without crossing", r)
+	}
+	if b.Pos.X > defs.NetPosX {
+		t.Errorf("ball landed at x=%.2f; want it to stay on the left side", b.Pos.X)
+	}
+}
+
+func TestBallLandsOutOfBounds(t *testing.T) {
+	b := &BallState{
+		Pos:          structures.Vector2{X: 5, Y: 4},
+		Vel:          structures.Vector2{X: 15, Y: 3},
+		GravityScale: 1,
+		LiveState:    BallLiveStateAlive,
+	}
+	r := simulateUntilLanded(b, 1000)
+	if !r.Landed || !r.OutOfBounds {
+		t.Errorf("ball step result = %+v; want landed out of bounds", r)
+	}
+}
+
+// check that a touch checked against a copy of the ball is refused if the ball lands before the touch is written, rather than bringing the ball back
+func TestTouchAfterLanding(t *testing.T) {
+	g := NewGameState()
+	ball := &BallState{Pos: structures.Vector2{X: -5, Y: 0.1}, Vel: structures.Vector2{X: 0, Y: -10}, LiveState: BallLiveStateAlive}
+	ball.GUID = "live-ball"
+	g.UpdateBall(ball.Clone())
+
+	// a touch is checked against a copy of the live ball
+	touched := g.GetBallCopy()
+	touched.TouchedBy = "player"
+	touched.TouchCount = 1
+
+	// meanwhile, the simulation lands the ball
+	dt := float32(1.0 / float64(defs.BallTickRate))
+	for i := 0; i < 100 && g.GetBallCopy() != nil; i++ {
+		g.StepBall(dt)
+	}
+	if g.GetBallCopy() != nil {
+		t.Fatalf("ball did not land")
+	}
+
+	// the touch comes too late
+	if g.TouchBall(touched.GUID, touched.Clone()) {
+		t.Errorf("touch on a ball that landed was accepted")
+	}
+	if g.GetBallCopy() != nil {
+		t.Errorf("touch brought a landed ball back")
+	}
+
+	// a touch on a live ball with another id is refused too, while one on the live ball is taken
+	g.UpdateBall(ball.Clone())
+	other := touched.Clone()
+	other.GUID = "other-ball"
+	if g.TouchBall(other.GUID, other) {
+		t.Errorf("touch on a ball that is not live was accepted")
+	}
+	if !g.TouchBall(touched.GUID, touched.Clone()) || g.GetBallCopy().TouchedBy != "player" {
+		t.Errorf("touch on the live ball was refused")
+	}
+}
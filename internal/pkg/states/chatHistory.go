@@ -0,0 +1,45 @@
+package states
+
+import (
+	"sync"
+
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
+)
+
+// a chat message as kept in an instance's history
+type ChatLine struct {
+	ServerPlayerID string // the id of the sender
+	DisplayName    string // the display name of the sender when they sent it
+	Text           string // the text of the message, with blocked words masked
+	TeamOnly       bool   // whether only the sender's team may see the message
+	Team           int    // the team that the sender was on
+	SentAt         int64  // the time the server received the message (unix milliseconds)
+}
+
+// the most recent chat messages of an instance, kept in a ring buffer
+type ChatHistory struct {
+	lines [defs.ChatHistorySize]ChatLine
+	next  int // the index that the next line is written to
+	count int // the number of lines held
+	mu    sync.Mutex
+}
+
+// add a line to the history, dropping the oldest one if it is full
+func (h *ChatHistory) Add(line ChatLine) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lines[h.next] = line
+	h.next = (h.next + 1) % len(h.lines)
+	h.count = min(h.count+1, len(h.lines))
+}
+
+// returns the lines held, oldest first
+func (h *ChatHistory) Lines() []ChatLine {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	lines := make([]ChatLine, 0, h.count)
+	for i := h.count; i > 0; i-- {
+		lines = append(lines, h.lines[(h.next-i+len(h.lines))%len(h.lines)])
+	}
+	return lines
+}
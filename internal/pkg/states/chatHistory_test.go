@@ -0,0 +1,55 @@
+package states
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
+)
+
+func TestChatHistoryKeepsLatest(t *testing.T) {
+	history := ChatHistory{}
+	if lines := history.Lines(); len(lines) != 0 {
+		t.Errorf("empty history has %d lines", len(lines))
+	}
+	for i := 0; i < defs.ChatHistorySize+3; i++ {
+		history.Add(ChatLine{Text: fmt.Sprint(i)})
+	}
+	lines := history.Lines()
+	if len(lines) != defs.ChatHistorySize || lines[0].Text != "3" || lines[len(lines)-1].Text != fmt.Sprint(defs.ChatHistorySize+2) {
+		t.Errorf("history holds %d lines from %q to %q; want the latest %d, oldest first", len(lines), lines[0].Text, lines[len(lines)-1].Text, defs.ChatHistorySize)
+	}
+}
+
+func TestTokenBucketLimitsRate(t *testing.T) {
+	bucket := TokenBucket{}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if !bucket.Allow(start, 3, time.Second) {
+			t.Fatalf("event %d of the burst was not allowed", i+1)
+		}
+	}
+	if bucket.Allow(start, 3, time.Second) {
+		t.Errorf("event past the burst was allowed")
+	}
+	if !bucket.Allow(start.Add(time.Second), 3, time.Second) {
+		t.Errorf("event after a refill was not allowed")
+	}
+	if bucket.Allow(start.Add(1500*time.Millisecond), 3, time.Second) {
+		t.Errorf("event before the next refill was allowed")
+	}
+	if !bucket.Allow(start.Add(time.Hour), 3, time.Second) {
+		t.Errorf("event after a long wait was not allowed")
+	}
+
+	// checking for a token does not take it
+	bucket = TokenBucket{}
+	if !bucket.Available(start, 1, time.Second) || !bucket.Available(start, 1, time.Second) {
+		t.Errorf("token of a new bucket was not available")
+	}
+	bucket.Allow(start, 1, time.Second)
+	if bucket.Available(start, 1, time.Second) {
+		t.Errorf("token was available after the only one was taken")
+	}
+}
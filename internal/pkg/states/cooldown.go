@@ -0,0 +1,23 @@
+package states
+
+import (
+	"sync"
+	"time"
+)
+
+// keeps something from happening again until a cooldown has passed
+type Cooldown struct {
+	until time.Time // the time the cooldown ends
+	mu    sync.Mutex
+}
+
+// start a cooldown of the given length if the previous one has ended; returns false if it has not
+func (c *Cooldown) Try(now time.Time, length time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Before(c.until) {
+		return false
+	}
+	c.until = now.Add(length)
+	return true
+}
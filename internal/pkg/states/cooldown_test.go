@@ -0,0 +1,20 @@
+package states
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCooldown(t *testing.T) {
+	cooldown := Cooldown{}
+	start := time.Now()
+	if !cooldown.Try(start, time.Second) {
+		t.Fatalf("first try was refused")
+	}
+	if cooldown.Try(start.Add(999*time.Millisecond), time.Second) {
+		t.Errorf("try during the cooldown was allowed")
+	}
+	if !cooldown.Try(start.Add(time.Second), time.Second) {
+		t.Errorf("try after the cooldown was refused")
+	}
+}
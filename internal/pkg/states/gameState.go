@@ -2,19 +2,26 @@ package states
 
 import (
 	"sync"
+	"time"
 )
 
 // represents a game instance on the server, with all its associated data stored
 type GameState struct {
 	RegisteredInstance
-	Ball *BallState `json:"Ball"`
-	mu   sync.Mutex // Mutex to protect concurrent access to Ball
+	Ball        *BallState      `json:"Ball"`
+	Match       *MatchState     `json:"-"`        // the score and rules of the match being played
+	Touches     TouchTracker    `json:"-"`        // the touches made on the ball during the current possession
+	BallHistory PositionHistory `json:"-"`        // the recent positions of the game ball, to rewind it to the time a client perceived it
+	RoomCode    string          `json:"RoomCode"` // the room code of the lobby that the game was started from, if any
+	lastTouch   AcceptedTouch   // the latest touch accepted on the game ball
+	mu          sync.Mutex      // Mutex to protect concurrent access to Ball
 }
 
 // initialize a new gameState object
 func NewGameState() *GameState {
 	gameState := &GameState{
-		Ball: nil, // no ball exists yet
+		Ball:  nil, // no ball exists yet
+		Match: NewMatchState(GameRules{}),
 	}
 	gameState.GenerateGUID()
 	gameState.RegisteredInstance.UpdateTime()
@@ -22,11 +29,31 @@ func NewGameState() *GameState {
 }
 
 // update the ball data on the map
+// * a ball with a new id starts a fresh history of positions and touches
 func (g *GameState) UpdateBall(b *BallState) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
+	if g.Ball == nil || g.Ball.GUID != b.GUID {
+		g.BallHistory.Clear()
+		g.lastTouch = AcceptedTouch{}
+	}
+	g.Ball = b
+	g.BallHistory.Record(time.Now(), b.Pos)
+	g.RegisteredInstance.UpdateTime()
+}
+
+// replace the live game ball with a touched copy of it, as long as it is still the ball with the expected id; returns false if it is not
+// * the ball may have landed or been killed since the touch was checked against a copy of it, in which case the touch is too late and must not bring the ball back
+func (g *GameState) TouchBall(expectedGUID string, b *BallState) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.Ball == nil || !g.Ball.IsAlive() || g.Ball.GUID != expectedGUID || b.GUID != expectedGUID {
+		return false
+	}
 	g.Ball = b
+	g.BallHistory.Record(time.Now(), b.Pos)
 	g.RegisteredInstance.UpdateTime()
+	return true
 }
 
 // return a copy of the game ball's data for threadsafe operations
@@ -38,3 +65,47 @@ func (g *GameState) GetBallCopy() *BallState {
 	}
 	return g.Ball.Clone()
 }
+
+// advance the live game ball by one simulation step and return a copy of its new state along with the step outcome
+// * returns nil if there is no live ball; if the ball lands, it is removed from the game
+func (g *GameState) StepBall(dt float32) (*BallState, BallStepResult) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.Ball == nil || !g.Ball.IsAlive() {
+		return nil, BallStepResult{}
+	}
+	result := g.Ball.Step(dt)
+	g.BallHistory.Record(time.Now(), g.Ball.Pos)
+	ball := g.Ball.Clone()
+	if result.Landed {
+		g.Ball = nil
+	}
+	return ball, result
+}
+
+// kill the live game ball if it has the given id, and return a copy of the dead ball
+// * returns nil if the ball is no longer live (e.g. it already landed)
+func (g *GameState) KillBall(guid string) *BallState {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.Ball == nil || g.Ball.GUID != guid {
+		return nil
+	}
+	ball := g.Ball.Clone()
+	ball.LiveState = BallLiveStateDead
+	g.Ball = nil
+	g.RegisteredInstance.UpdateTime()
+	return ball
+}
+
+// expose the latest touch accepted on the game ball
+func (g *GameState) SetLastTouch(t AcceptedTouch) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.lastTouch = t
+}
+func (g *GameState) LastTouch() AcceptedTouch {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.lastTouch
+}
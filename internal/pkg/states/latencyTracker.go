@@ -0,0 +1,68 @@
+package states
+
+import (
+	"sync"
+
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
+)
+
+// tracks a smoothed estimate of the round trip time to a player's client, measured over successive pings
+// * the estimates are weighted moving averages in the style of TCP, so a single slow ping does not throw them off
+type LatencyTracker struct {
+	rtt       float64 // the smoothed round trip time in milliseconds
+	jitter    float64 // the smoothed deviation of the round trip time in milliseconds
+	samples   int     // the number of round trips measured so far
+	lastReply int64   // the server send time of the latest ping reply to the player, which the client echoes on its next ping
+	mu        sync.Mutex
+}
+
+// record the server send time of a ping reply sent to the player
+func (l *LatencyTracker) ReplySent(serverSendTime int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastReply = serverSendTime
+}
+
+// measure a round trip from a ping that echoes the previous reply, given the times that the client received that reply and sent the ping (client clock), and the time the ping was received (server clock)
+// * the time the client held on to the reply before pinging again is not part of the round trip
+// * returns false if the echo does not match the latest reply sent to the player, or the times are inconsistent
+func (l *LatencyTracker) Measure(prevServerSend int64, prevClientRecv int64, clientSend int64, serverRecv int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if prevServerSend == 0 || prevServerSend != l.lastReply || prevClientRecv > clientSend {
+		return false
+	}
+	sample := float64((serverRecv - prevServerSend) - (clientSend - prevClientRecv))
+	if sample < 0 || sample > defs.MaxRTTSampleMs {
+		return false
+	}
+
+	// the first sample seeds the estimates, then later ones are blended in
+	if l.samples == 0 {
+		l.rtt = sample
+		l.jitter = sample / 2
+	} else {
+		deviation := sample - l.rtt
+		if deviation < 0 {
+			deviation = -deviation
+		}
+		l.jitter += defs.JitterSmoothing * (deviation - l.jitter)
+		l.rtt += defs.RTTSmoothing * (sample - l.rtt)
+	}
+	l.samples++
+	return true
+}
+
+// returns the smoothed round trip time and jitter in milliseconds, which are zero until a round trip has been measured
+func (l *LatencyTracker) RTT() (float64, float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rtt, l.jitter
+}
+
+// returns whether a round trip has been measured yet
+func (l *LatencyTracker) Measured() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.samples > 0
+}
@@ -0,0 +1,38 @@
+package states
+
+import (
+	"testing"
+)
+
+func TestLatencyTrackerMeasure(t *testing.T) {
+	tracker := LatencyTracker{}
+	if tracker.Measure(1000, 1050, 1060, 1110) {
+		t.Errorf("a round trip was measured from an echo of a reply that was never sent")
+	}
+
+	// a reply sent at 1000 is received by the client 50ms later (client clock is 500ms ahead), which pings again 200ms after that
+	tracker.ReplySent(1000)
+	if !tracker.Measure(1000, 1550, 1750, 1300) {
+		t.Fatalf("a valid round trip was not measured")
+	}
+	if rtt, jitter := tracker.RTT(); rtt != 100 || jitter != 50 {
+		t.Errorf("rtt, jitter = %v, %v; want 100, 50 from the first sample", rtt, jitter)
+	}
+
+	// later samples are blended in
+	tracker.ReplySent(2000)
+	if !tracker.Measure(2000, 2500, 2500, 2180) {
+		t.Fatalf("a valid round trip was not measured")
+	}
+	if rtt, jitter := tracker.RTT(); rtt != 110 || jitter != 57.5 {
+		t.Errorf("rtt, jitter = %v, %v; want 110, 57.5 after a 180ms sample", rtt, jitter)
+	}
+
+	// stale echoes and inconsistent times are ignored
+	if tracker.Measure(1000, 1550, 1750, 1300) {
+		t.Errorf("a round trip was measured from an echo of an older reply")
+	}
+	if tracker.Measure(2000, 2600, 2500, 2180) {
+		t.Errorf("a round trip was measured from a ping sent before the reply was received")
+	}
+}
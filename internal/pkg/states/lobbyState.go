@@ -1,9 +1,15 @@
 package states
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/util"
 )
 
 // represents a game instance on the server, with all its associated data stored
@@ -11,6 +17,25 @@ type LobbyState struct {
 	RegisteredInstance
 	RoomCode string `json:"RoomCode"`   // the room code that players can enter to join
 	Backdrop string `json:"Background"` // the string code for the background asset
+	GameID   string `json:"GameID"`     // the id of the game currently being played by the lobby, if any
+	IsPublic bool   `json:"IsPublic"`   // whether the lobby is listed to players browsing for one, rather than only joined by its room code
+
+	// match setup
+	Ready          sync.Map      // the players who are ready to start the match (key: string; value: dummy flag (boolean))
+	ReadyQuorum    int           // the number of ready players needed to start the match, set by the host; zero means everyone
+	Rules          GameRules     // the rules of the next match, set by the host
+	countdown      chan struct{} // closed to cancel the running countdown; nil if no countdown is running
+	countdownMutex sync.Mutex
+
+	// moderation by the host
+	banned sync.Map    // the players and sessions kept from rejoining the lobby (key: player GUID or session id; value: dummy flag (boolean))
+	locked atomic.Bool // whether new players and spectators are kept from joining
+
+	// access to a private lobby
+	passwordSalt string          // the salt of the password's hash; empty if the lobby has no password
+	passwordHash []byte          // the salted hash of the password; the password itself is never kept
+	invites      map[string]bool // the one-time tokens that let players in without the password (key: token; value: dummy flag)
+	accessMutex  sync.Mutex
 }
 
 // initialize a new gameState object
@@ -55,11 +80,145 @@ const NumRoomCodeChars = 4
 // create a game instance and migrate the current instance information to it
 func (l *LobbyState) CreateGameInstance() *GameState {
 	g := NewGameState()
+	guid := g.GUID
 	g.RegisteredInstance = *l.RegisteredInstance.Clone()
+	g.GUID = guid // keep the game's own id rather than the lobby's
+	g.RoomCode = l.RoomCode
 	g.RegisteredInstance.UpdateTime()
 	return g
 }
 
+// returns the number of ready players needed to start a match, given the number of players in the lobby
+func (l *LobbyState) RequiredReady(numPlayers int) int {
+	if l.ReadyQuorum > 0 && l.ReadyQuorum < numPlayers {
+		return l.ReadyQuorum
+	}
+	return numPlayers
+}
+
+// begin a countdown and return the channel that is closed if it gets cancelled; returns false if a countdown is already running
+func (l *LobbyState) StartCountdown() (chan struct{}, bool) {
+	l.countdownMutex.Lock()
+	defer l.countdownMutex.Unlock()
+	if l.countdown != nil {
+		return nil, false
+	}
+	l.countdown = make(chan struct{})
+	return l.countdown, true
+}
+
+// cancel the running countdown; returns false if there was none
+func (l *LobbyState) CancelCountdown() bool {
+	l.countdownMutex.Lock()
+	defer l.countdownMutex.Unlock()
+	if l.countdown == nil {
+		return false
+	}
+	close(l.countdown)
+	l.countdown = nil
+	return true
+}
+
+// mark the given countdown as completed; returns false if it was cancelled in the meantime
+func (l *LobbyState) FinishCountdown(countdown chan struct{}) bool {
+	l.countdownMutex.Lock()
+	defer l.countdownMutex.Unlock()
+	if l.countdown != countdown {
+		return false
+	}
+	l.countdown = nil
+	return true
+}
+
+// keep the given players or sessions from joining the lobby for as long as it exists
+// * players get a new GUID each time they are admitted, so a ban should also name the session that the player connected from
+func (l *LobbyState) Ban(ids ...string) {
+	for _, id := range ids {
+		if len(id) > 0 {
+			l.banned.Store(id, true)
+		}
+	}
+}
+
+// returns whether any of the given players or sessions are banned from the lobby
+func (l *LobbyState) IsBanned(ids ...string) bool {
+	for _, id := range ids {
+		if _, ok := l.banned.Load(id); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// lock or unlock the lobby against new joins
+func (l *LobbyState) SetLocked(locked bool) {
+	l.locked.Store(locked)
+}
+func (l *LobbyState) IsLocked() bool {
+	return l.locked.Load()
+}
+
+// protect the lobby with a password, which is kept only as a salted hash; an empty password opens the lobby up again
+func (l *LobbyState) SetPassword(password string) {
+	l.accessMutex.Lock()
+	defer l.accessMutex.Unlock()
+	if len(password) == 0 {
+		l.passwordSalt, l.passwordHash = "", nil
+		return
+	}
+	l.passwordSalt = util.RandomToken(defs.PasswordSaltBytes)
+	l.passwordHash = hashPassword(l.passwordSalt, password)
+}
+
+// returns whether players need the password or an invite to join the lobby
+func (l *LobbyState) RequiresPassword() bool {
+	l.accessMutex.Lock()
+	defer l.accessMutex.Unlock()
+	return l.passwordHash != nil
+}
+
+// returns whether the password matches the lobby's; always true if the lobby has no password
+func (l *LobbyState) CheckPassword(password string) bool {
+	l.accessMutex.Lock()
+	defer l.accessMutex.Unlock()
+	if l.passwordHash == nil {
+		return true
+	}
+	return subtle.ConstantTimeCompare(hashPassword(l.passwordSalt, password), l.passwordHash) == 1
+}
+
+// create a one-time token that lets a player into the lobby without its password; returns false if too many tokens are waiting to be used
+func (l *LobbyState) CreateInvite() (string, bool) {
+	l.accessMutex.Lock()
+	defer l.accessMutex.Unlock()
+	if len(l.invites) >= defs.MaxPendingInvites {
+		return "", false
+	}
+	if l.invites == nil {
+		l.invites = make(map[string]bool)
+	}
+	token := util.RandomToken(defs.InviteTokenBytes)
+	l.invites[token] = true
+	return token, true
+}
+
+// use up an invite token; returns false if the lobby did not issue it or it was already used
+func (l *LobbyState) RedeemInvite(token string) bool {
+	l.accessMutex.Lock()
+	defer l.accessMutex.Unlock()
+	if !l.invites[token] {
+		return false
+	}
+	delete(l.invites, token)
+	return true
+}
+
+// returns the salted hash of a lobby password
+func hashPassword(salt string, password string) []byte {
+	sum := sha256.Sum256([]byte(salt + password))
+	return sum[:]
+}
+
 // generates 4 random consonents
 func randomConsonants(length int) string {
 	consonants := "BCDFGHJKLMNPQRSTVWXZ"
@@ -0,0 +1,69 @@
+package states
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
+)
+
+// check that lobby passwords are kept salted and hashed, and can be removed again
+func TestLobbyPassword(t *testing.T) {
+	var lobbies sync.Map
+	lobby := NewLobbyState(&lobbies)
+	if lobby.RequiresPassword() || !lobby.CheckPassword("") {
+		t.Fatalf("new lobby requires a password")
+	}
+
+	lobby.SetPassword("spike")
+	if !lobby.RequiresPassword() {
+		t.Errorf("lobby with a password does not require it")
+	}
+	if !lobby.CheckPassword("spike") || lobby.CheckPassword("Spike") || lobby.CheckPassword("") {
+		t.Errorf("password check does not match only the password that was set")
+	}
+	if bytes.Contains(lobby.passwordHash, []byte("spike")) || len(lobby.passwordSalt) == 0 {
+		t.Errorf("password is not kept as a salted hash")
+	}
+
+	// the same password gets a different salt each time it is set
+	hash := lobby.passwordHash
+	lobby.SetPassword("spike")
+	if bytes.Equal(hash, lobby.passwordHash) {
+		t.Errorf("setting the same password again gave the same hash")
+	}
+
+	lobby.SetPassword("")
+	if lobby.RequiresPassword() || !lobby.CheckPassword("anything") {
+		t.Errorf("lobby still requires a password after it was removed")
+	}
+}
+
+// check that invites can each be used once, and that only so many may wait to be used
+func TestLobbyInvites(t *testing.T) {
+	var lobbies sync.Map
+	lobby := NewLobbyState(&lobbies)
+	token, ok := lobby.CreateInvite()
+	if !ok || len(token) == 0 {
+		t.Fatalf("creating an invite failed")
+	}
+	if lobby.RedeemInvite("made-up") {
+		t.Errorf("an invite that was never issued was accepted")
+	}
+	if !lobby.RedeemInvite(token) {
+		t.Errorf("an issued invite was refused")
+	}
+	if lobby.RedeemInvite(token) {
+		t.Errorf("an invite was accepted twice")
+	}
+
+	for i := 0; i < defs.MaxPendingInvites; i++ {
+		if _, ok := lobby.CreateInvite(); !ok {
+			t.Fatalf("creating invite %d failed", i+1)
+		}
+	}
+	if _, ok := lobby.CreateInvite(); ok {
+		t.Errorf("created more than %d unused invites", defs.MaxPendingInvites)
+	}
+}
@@ -0,0 +1,151 @@
+package states
+
+import (
+	"sync"
+
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
+)
+
+// team indices, used to index per-team values
+const (
+	TeamLeft  = 0
+	TeamRight = 1
+)
+
+// returns the team whose side of the court contains the given x position
+func TeamAtPosX(x float32) int {
+	if x > defs.NetPosX {
+		return TeamRight
+	}
+	return TeamLeft
+}
+
+// returns the team opposing the given team
+func OpposingTeam(team int) int {
+	return 1 - team
+}
+
+// the configurable rules of a match; zero values are replaced by the defaults
+type GameRules struct {
+	PointsPerSet      int  `json:"PointsPerSet"`      // the points needed to win a regular set
+	PointsDecidingSet int  `json:"PointsDecidingSet"` // the points needed to win the final deciding set
+	WinBy             int  `json:"WinBy"`             // the minimum lead needed to win a set
+	BestOf            int  `json:"BestOf"`            // the maximum number of sets played in a match
+	MaxTouches        int  `json:"MaxTouches"`        // the maximum number of touches a team may make before sending the ball over the net
+	DisableTouchRules bool `json:"DisableTouchRules"` // if true, the touch limit and double touches are not enforced (e.g. for casual lobbies)
+	MaxRewindMs       int  `json:"MaxRewindMs"`       // the furthest back in time (in milliseconds) that a touch is rewound to, to make up for the toucher's latency
+}
+
+// return a copy of the rules with any unset values replaced by the defaults
+func (r GameRules) WithDefaults() GameRules {
+	if r.PointsPerSet <= 0 {
+		r.PointsPerSet = defs.DefaultPointsPerSet
+	}
+	if r.PointsDecidingSet <= 0 {
+		r.PointsDecidingSet = defs.DefaultPointsDecidingSet
+	}
+	if r.WinBy <= 0 {
+		r.WinBy = defs.DefaultWinBy
+	}
+	if r.BestOf <= 0 {
+		r.BestOf = defs.DefaultBestOf
+	}
+	if r.MaxTouches <= 0 {
+		r.MaxTouches = defs.DefaultMaxTouches
+	}
+	if r.MaxRewindMs <= 0 {
+		r.MaxRewindMs = defs.DefaultMaxRewindMs
+	}
+	r.MaxRewindMs = min(r.MaxRewindMs, defs.MaxRewindMs)
+	return r
+}
+
+// a snapshot of the score of a match, indexed by team where applicable
+type MatchScore struct {
+	Points      [2]int `json:"Points"`      // the points of each team in the current set
+	SetsWon     [2]int `json:"SetsWon"`     // the number of sets won by each team
+	SetNumber   int    `json:"SetNumber"`   // the set currently being played, starting from 1
+	ServingTeam int    `json:"ServingTeam"` // the team that serves the next rally
+	Rotation    [2]int `json:"Rotation"`    // the serve rotation of each team, advanced whenever the team wins back the serve
+	RallyCount  int    `json:"RallyCount"`  // the number of rallies played in the match
+	IsOver      bool   `json:"IsOver"`      // whether the match has ended
+	WinningTeam int    `json:"WinningTeam"` // the team that won the match, if it has ended
+}
+
+// the outcome of awarding a rally to a team
+type RallyOutcome struct {
+	SetEnded   bool // the rally ended the current set
+	MatchEnded bool // the rally ended the match
+}
+
+// tracks the score of a match according to its rules
+type MatchState struct {
+	Rules         GameRules
+	score         MatchScore
+	setFirstServe int // the team that served first in the current set
+	mu            sync.Mutex
+}
+
+// initialize a new match with the given rules
+func NewMatchState(rules GameRules) *MatchState {
+	m := &MatchState{
+		Rules: rules.WithDefaults(),
+	}
+	m.score.SetNumber = 1
+	m.score.ServingTeam = TeamLeft
+	m.setFirstServe = TeamLeft
+	return m
+}
+
+// return a copy of the current score
+func (m *MatchState) Score() MatchScore {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.score
+}
+
+// award a rally to the given team, rotating the serve and ending the set or match as necessary
+// * returns false if the match is already over and the rally could not be awarded
+func (m *MatchState) AwardRally(team int) (RallyOutcome, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	outcome := RallyOutcome{}
+	if m.score.IsOver {
+		return outcome, false
+	}
+
+	// award the point, and rotate the serve if the team won it back from the opponents (rally-point scoring)
+	m.score.RallyCount++
+	m.score.Points[team]++
+	if m.score.ServingTeam != team {
+		m.score.ServingTeam = team
+		m.score.Rotation[team]++
+	}
+
+	// check whether the set has been won
+	target := m.Rules.PointsPerSet
+	if m.score.SetNumber >= m.Rules.BestOf {
+		target = m.Rules.PointsDecidingSet
+	}
+	lead := m.score.Points[team] - m.score.Points[OpposingTeam(team)]
+	if m.score.Points[team] < target || lead < m.Rules.WinBy {
+		return outcome, true
+	}
+	outcome.SetEnded = true
+	m.score.SetsWon[team]++
+
+	// check whether the match has been won
+	if m.score.SetsWon[team] > m.Rules.BestOf/2 {
+		outcome.MatchEnded = true
+		m.score.IsOver = true
+		m.score.WinningTeam = team
+		return outcome, true
+	}
+
+	// begin the next set, with the first serve alternating between sets
+	m.score.Points = [2]int{}
+	m.score.SetNumber++
+	m.setFirstServe = OpposingTeam(m.setFirstServe)
+	m.score.ServingTeam = m.setFirstServe
+	return outcome, true
+}
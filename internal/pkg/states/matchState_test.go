@@ -0,0 +1,71 @@
+package states
+
+import (
+	"testing"
+
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
+)
+
+// award a number of consecutive rallies to a team
+func awardRallies(m *MatchState, team int, n int) RallyOutcome {
+	outcome := RallyOutcome{}
+	for i := 0; i < n; i++ {
+		outcome, _ = m.AwardRally(team)
+	}
+	return outcome
+}
+
+func TestMatchSetRequiresWinBy(t *testing.T) {
+	m := NewMatchState(GameRules{PointsPerSet: 5, BestOf: 3})
+	awardRallies(m, TeamLeft, 4)
+	awardRallies(m, TeamRight, 4)
+	outcome := awardRallies(m, TeamLeft, 1)
+	if outcome.SetEnded {
+		t.Errorf("set ended at 5-4; want the set to continue until a lead of 2")
+	}
+	outcome = awardRallies(m, TeamLeft, 1)
+	if !outcome.SetEnded {
+		t.Errorf("set did not end at 6-4; want it to end")
+	}
+	score := m.Score()
+	if score.SetNumber != 2 || score.SetsWon[TeamLeft] != 1 || score.Points != [2]int{} {
+		t.Errorf("score after set = %+v; want set 2 with left leading 1-0 in sets", score)
+	}
+}
+
+func TestMatchServeRotation(t *testing.T) {
+	m := NewMatchState(GameRules{})
+	awardRallies(m, TeamRight, 1)
+	awardRallies(m, TeamRight, 1)
+	awardRallies(m, TeamLeft, 1)
+	score := m.Score()
+	if score.ServingTeam != TeamLeft || score.Rotation != [2]int{1, 1} || score.RallyCount != 3 {
+		t.Errorf("score after rallies = %+v; want left serving with one rotation per team", score)
+	}
+}
+
+func TestMatchEndsBestOf(t *testing.T) {
+	m := NewMatchState(GameRules{PointsPerSet: 3, PointsDecidingSet: 2, BestOf: 3})
+	awardRallies(m, TeamLeft, 3)
+	awardRallies(m, TeamRight, 3)
+	outcome := awardRallies(m, TeamRight, 2)
+	if !outcome.MatchEnded {
+		t.Errorf("match did not end after the deciding set; want it to end")
+	}
+	if _, ok := m.AwardRally(TeamLeft); ok {
+		t.Errorf("rally awarded after the match ended; want it to be refused")
+	}
+	score := m.Score()
+	if !score.IsOver || score.WinningTeam != TeamRight || score.SetsWon != [2]int{1, 2} {
+		t.Errorf("final score = %+v; want right to win 2-1", score)
+	}
+}
+
+func TestRulesRewindWindow(t *testing.T) {
+	if rules := (GameRules{}).WithDefaults(); rules.MaxRewindMs != defs.DefaultMaxRewindMs {
+		t.Errorf("default rewind window = %d; want %d", rules.MaxRewindMs, defs.DefaultMaxRewindMs)
+	}
+	if rules := (GameRules{MaxRewindMs: 5000}).WithDefaults(); rules.MaxRewindMs != defs.MaxRewindMs {
+		t.Errorf("rewind window = %d; want it capped at %d", rules.MaxRewindMs, defs.MaxRewindMs)
+	}
+}
@@ -0,0 +1,128 @@
+package states
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
+)
+
+// kinds of movement violations
+const (
+	ViolationSpeed    = "speed"    // the player moved or reported a velocity faster than their Speed stat allows
+	ViolationJump     = "jump"     // the player rose or reported a velocity higher than their Jump stat allows
+	ViolationNet      = "net"      // the player crossed over to the other side of the net
+	ViolationTeleport = "teleport" // the player moved too far to be clamped, and the update was rejected
+)
+
+// the outcome of checking a player's movement update
+type MoveCheck struct {
+	Action     PlayerAction // the action to accept, with any violations clamped to the player's limits
+	Violations []string     // the kinds of violations found, if any
+	Rejected   bool         // whether the update was too far off to be clamped, and the player should be corrected to their previous action instead
+}
+
+// returns a description of the violations of a movement check, for logging
+func (c MoveCheck) String() string {
+	return fmt.Sprintf("%v (rejected: %t)", c.Violations, c.Rejected)
+}
+
+// returns the fastest that a player may run
+func MaxRunSpeed(attrs PlayerAttributes) float32 {
+	return (defs.BaseRunSpeed + attrs.Speed*defs.RunSpeedPerStat) * defs.MovementTolerance
+}
+
+// returns the highest that a player's feet may get above the floor, along with the fastest they may rise
+func MaxJump(attrs PlayerAttributes) (float32, float32) {
+	speed := (defs.BaseJumpSpeed + attrs.Jump*defs.JumpSpeedPerStat) * defs.MovementTolerance
+	return speed * speed / (2 * -defs.Gravity), speed
+}
+
+// check a player's movement update against their previous action, the time since it and their stats
+// * elapsed is zero if the time of the previous action is unknown, in which case the distance moved is not checked
+func CheckMovement(prev PlayerAction, next PlayerAction, elapsed time.Duration, attrs PlayerAttributes) MoveCheck {
+	check := MoveCheck{Action: next}
+	flag := func(kind string) {
+		check.Violations = append(check.Violations, kind)
+	}
+	runSpeed := MaxRunSpeed(attrs)
+	jumpHeight, jumpSpeed := MaxJump(attrs)
+
+	// the player must stay on their own side of the net
+	if team := TeamAtPosX(prev.Pos.X); TeamAtPosX(next.Pos.X) != team {
+		check.Action.Pos.X = defs.NetPosX + defs.NetClearance
+		if team == TeamLeft {
+			check.Action.Pos.X = defs.NetPosX - defs.NetClearance
+		}
+		flag(ViolationNet)
+	}
+
+	// the distance moved must be within the player's speed, or the update is rejected outright if it is far off
+	if elapsed > 0 {
+		seconds := float32(min(max(elapsed.Seconds(), defs.MovementSlackSeconds), defs.MaxMoveElapsedSeconds))
+		allowed := runSpeed * seconds
+		moved := check.Action.Pos.X - prev.Pos.X
+		if moved > allowed*defs.TeleportFactor || -moved > allowed*defs.TeleportFactor {
+			return MoveCheck{Action: prev, Violations: []string{ViolationTeleport}, Rejected: true}
+		}
+		if moved > allowed || -moved > allowed {
+			check.Action.Pos.X = prev.Pos.X + min(max(moved, -allowed), allowed)
+			flag(ViolationSpeed)
+		}
+	}
+
+	// the reported velocity must be within the player's speed and jump
+	if check.Action.Vel.X > runSpeed || check.Action.Vel.X < -runSpeed {
+		check.Action.Vel.X = min(max(check.Action.Vel.X, -runSpeed), runSpeed)
+		flag(ViolationSpeed)
+	}
+	if check.Action.Vel.Y > jumpSpeed {
+		check.Action.Vel.Y = jumpSpeed
+		flag(ViolationJump)
+	}
+
+	// the player may not rise higher than their jump allows
+	if maxY := float32(defs.FloorY) + jumpHeight; check.Action.Pos.Y > maxY {
+		check.Action.Pos.Y = maxY
+		flag(ViolationJump)
+	}
+	return check
+}
+
+// counts the movement violations made by a player, for moderation
+type ViolationCounter struct {
+	counts  map[string]int // the number of violations of each kind
+	total   int
+	flagged bool // whether the player has made enough violations to be flagged for moderation
+	mu      sync.Mutex
+}
+
+// add violations to the count; returns true if this flagged the player
+func (v *ViolationCounter) Add(kinds []string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.counts == nil {
+		v.counts = make(map[string]int)
+	}
+	for _, kind := range kinds {
+		v.counts[kind]++
+		v.total++
+	}
+	if !v.flagged && v.total >= defs.MovementFlagThreshold {
+		v.flagged = true
+		return true
+	}
+	return false
+}
+
+// returns a copy of the number of violations of each kind, along with the total and whether the player was flagged
+func (v *ViolationCounter) Counts() (map[string]int, int, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	counts := make(map[string]int, len(v.counts))
+	for kind, n := range v.counts {
+		counts[kind] = n
+	}
+	return counts, v.total, v.flagged
+}
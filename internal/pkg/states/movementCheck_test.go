@@ -0,0 +1,71 @@
+package states
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/structures"
+)
+
+func TestCheckMovement(t *testing.T) {
+	attrs := PlayerAttributes{Speed: 2, Jump: 2}
+	prev := PlayerAction{Pos: structures.Vector2{X: 5, Y: 0}}
+	runSpeed := MaxRunSpeed(attrs)
+	jumpHeight, jumpSpeed := MaxJump(attrs)
+
+	// a move within the player's limits is accepted as is
+	next := PlayerAction{Pos: structures.Vector2{X: 5 + runSpeed/2, Y: jumpHeight / 2}, Vel: structures.Vector2{X: runSpeed, Y: jumpSpeed}}
+	if check := CheckMovement(prev, next, time.Second, attrs); len(check.Violations) > 0 || check.Rejected || check.Action != next {
+		t.Errorf("legal move check = %+v; want it accepted as is", check)
+	}
+
+	// moves beyond the player's limits are clamped to them
+	next = PlayerAction{Pos: structures.Vector2{X: 5 + runSpeed*2, Y: jumpHeight * 2}, Vel: structures.Vector2{X: -runSpeed * 2, Y: jumpSpeed * 2}}
+	check := CheckMovement(prev, next, time.Second, attrs)
+	if check.Rejected || !slices.Contains(check.Violations, ViolationSpeed) || !slices.Contains(check.Violations, ViolationJump) {
+		t.Errorf("fast move check = %+v; want speed and jump violations clamped", check)
+	}
+	if check.Action.Pos.X != 5+runSpeed || check.Action.Pos.Y != jumpHeight || check.Action.Vel.X != -runSpeed || check.Action.Vel.Y != jumpSpeed {
+		t.Errorf("clamped action = %+v; want it at the player's limits", check.Action)
+	}
+
+	// crossing the net pushes the player back to their side
+	next = PlayerAction{Pos: structures.Vector2{X: -0.5}}
+	check = CheckMovement(PlayerAction{Pos: structures.Vector2{X: 0.5}}, next, time.Second, attrs)
+	if !slices.Contains(check.Violations, ViolationNet) || TeamAtPosX(check.Action.Pos.X) != TeamRight {
+		t.Errorf("net crossing check = %+v; want the player kept on the right side", check)
+	}
+
+	// a teleport is rejected, even if the updates arrived bunched up
+	next = PlayerAction{Pos: structures.Vector2{X: 11}}
+	check = CheckMovement(prev, next, time.Millisecond, attrs)
+	if !check.Rejected || check.Action != prev {
+		t.Errorf("teleport check = %+v; want it rejected", check)
+	}
+
+	// the distance moved is not checked if the time of the previous action is unknown
+	if check := CheckMovement(prev, next, 0, attrs); check.Rejected || len(check.Violations) > 0 {
+		t.Errorf("first move check = %+v; want it accepted", check)
+	}
+}
+
+func TestViolationCounterFlags(t *testing.T) {
+	counter := ViolationCounter{}
+	for i := 1; i < defs.MovementFlagThreshold; i++ {
+		if counter.Add([]string{ViolationSpeed}) {
+			t.Fatalf("player flagged after %d violations", i)
+		}
+	}
+	if !counter.Add([]string{ViolationNet}) {
+		t.Errorf("player not flagged after %d violations", defs.MovementFlagThreshold)
+	}
+	if counter.Add([]string{ViolationNet}) {
+		t.Errorf("player flagged more than once")
+	}
+	counts, total, flagged := counter.Counts()
+	if counts[ViolationSpeed] != defs.MovementFlagThreshold-1 || counts[ViolationNet] != 2 || total != defs.MovementFlagThreshold+1 || !flagged {
+		t.Errorf("counts = %v, %d, %t; want each kind counted", counts, total, flagged)
+	}
+}
@@ -1,25 +1,36 @@
 package states
 
 import (
-	"net"
+	"sync"
+	"time"
 )
 
 // a container to store clients' session info,
 type PlayerState struct {
-	PlayerAction               // ingame transient data
-	PlayerAttributes           // ingame constant data
-	ExpirableInstance          // for handling user timeouts
-	addr              net.Addr // make private for to avoid sending the address out
-	GameID            string   // the id of the game the user is connected to, if any
-	RoomCode          string   // the room code of the lobby that the user is connected to, if any
+	PlayerAction                       // ingame transient data
+	PlayerAttributes                   // ingame constant data
+	ExpirableInstance                  // for handling user timeouts
+	sessionID         string           // the id of the connection session that the user is attached to; private to avoid sending it out
+	GameID            string           // the id of the game the user is connected to, if any
+	RoomCode          string           // the room code of the lobby that the user is connected to, if any
+	IsSpectator       bool             // whether the user is only spectating their game or lobby
+	resumeToken       string           // the secret token that lets the user resume this session from a new connection
+	disconnectTime    time.Time        // the time that the user's connection was lost; zero if they are connected
+	Latency           LatencyTracker   // the smoothed round trip time to the user's client, measured over pings
+	PosHistory        PositionHistory  // the recent positions of the user's player, as reported by their client
+	Violations        ViolationCounter // the movement violations made by the user, for moderation
+	ChatLimiter       TokenBucket      // limits how often the user may chat
+	EmoteCooldown     Cooldown         // keeps the user from firing emotes too often
+	muted             map[string]bool  // the players whose chat the user does not want to see (key: player.GUID)
+	connMutex         sync.Mutex
 }
 
-// create a new client container for a user with speicified address
-func NewPlayer(address net.Addr) *PlayerState {
+// create a new client container for a user attached to the specified session
+func NewPlayer(sessionID string) *PlayerState {
 	client := &PlayerState{
-		addr:     address,
-		GameID:   "",
-		RoomCode: "",
+		sessionID: sessionID,
+		GameID:    "",
+		RoomCode:  "",
 	}
 	client.ExpirableInstance.GenerateGUID()
 	client.ExpirableInstance.UpdateTime()
@@ -29,6 +40,7 @@ func NewPlayer(address net.Addr) *PlayerState {
 // updpate the player's game state
 func (r *PlayerState) UpdatePlayerState(p *PlayerAction) {
 	r.PlayerAction = *p
+	r.PosHistory.Record(time.Now(), p.Pos)
 	r.UpdateTime()
 }
 
@@ -38,10 +50,75 @@ func (r *PlayerState) UpdatePlayerAttributes(p *PlayerAttributes) {
 	r.UpdateTime()
 }
 
-// expose private address variable
-func (r *PlayerState) SetAddress(a net.Addr) {
-	r.addr = a
+// expose private session id variable
+func (r *PlayerState) SetSessionID(id string) {
+	r.connMutex.Lock()
+	defer r.connMutex.Unlock()
+	r.sessionID = id
 }
-func (r *PlayerState) GetAddress() net.Addr {
-	return r.addr
+func (r *PlayerState) GetSessionID() string {
+	r.connMutex.Lock()
+	defer r.connMutex.Unlock()
+	return r.sessionID
+}
+
+// expose private resume token variable
+func (r *PlayerState) SetResumeToken(token string) {
+	r.connMutex.Lock()
+	defer r.connMutex.Unlock()
+	r.resumeToken = token
+}
+func (r *PlayerState) GetResumeToken() string {
+	r.connMutex.Lock()
+	defer r.connMutex.Unlock()
+	return r.resumeToken
+}
+
+// mark the player as having lost their connection, and return the time that it happened
+func (r *PlayerState) MarkDisconnected() time.Time {
+	r.connMutex.Lock()
+	defer r.connMutex.Unlock()
+	r.disconnectTime = time.Now()
+	return r.disconnectTime
+}
+
+// attach the player to a new session after they were disconnected
+func (r *PlayerState) Reattach(sessionID string) {
+	r.connMutex.Lock()
+	defer r.connMutex.Unlock()
+	r.sessionID = sessionID
+	r.disconnectTime = time.Time{}
+}
+
+// returns whether the player has lost their connection and not been attached to a new one
+func (r *PlayerState) IsDisconnected() bool {
+	r.connMutex.Lock()
+	defer r.connMutex.Unlock()
+	return !r.disconnectTime.IsZero()
+}
+
+// returns whether the player is still disconnected since the given disconnection time
+func (r *PlayerState) IsDisconnectedSince(t time.Time) bool {
+	r.connMutex.Lock()
+	defer r.connMutex.Unlock()
+	return !r.disconnectTime.IsZero() && r.disconnectTime.Equal(t)
+}
+
+// expose the private list of players that the user has muted
+func (r *PlayerState) SetMuted(playerID string, muted bool) {
+	r.connMutex.Lock()
+	defer r.connMutex.Unlock()
+	if r.muted == nil {
+		r.muted = make(map[string]bool)
+	}
+	if muted {
+		r.muted[playerID] = true
+	} else {
+		delete(r.muted, playerID)
+	}
+}
+func (r *PlayerState) HasMuted(playerID string) bool {
+	r.connMutex.Lock()
+	defer r.connMutex.Unlock()
+	return r.muted[playerID]
 }
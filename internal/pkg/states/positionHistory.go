@@ -0,0 +1,73 @@
+package states
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
+	st "github.com/Isthatok74/PaperVolleyballServer/internal/pkg/structures"
+)
+
+// a short history of an object's positions as seen by the server, used to rewind it to the time that a client perceived it
+type PositionHistory struct {
+	samples [defs.PositionHistorySize]positionSample // a ring buffer of the latest samples
+	next    int                                      // the index that the next sample is written to
+	count   int                                      // the number of samples held
+	mu      sync.Mutex
+}
+
+// the position of an object at a point in time
+type positionSample struct {
+	time time.Time
+	pos  st.Vector2
+}
+
+// record the position of the object at a point in time, which should not be older than the previous sample
+func (h *PositionHistory) Record(t time.Time, pos st.Vector2) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.samples[h.next] = positionSample{time: t, pos: pos}
+	h.next = (h.next + 1) % len(h.samples)
+	h.count = min(h.count+1, len(h.samples))
+}
+
+// returns the position of the object at a point in time, interpolated between the samples around it
+// * times after the latest sample return the latest position; returns false if the time is older than the history or nothing was recorded
+func (h *PositionHistory) At(t time.Time) (st.Vector2, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// walk back from the latest sample until one at or before the time is found
+	newer := positionSample{}
+	for i := 1; i <= h.count; i++ {
+		sample := h.samples[(h.next-i+len(h.samples))%len(h.samples)]
+		if sample.time.After(t) {
+			newer = sample
+			continue
+		}
+		if i == 1 || !sample.time.Before(newer.time) {
+			return sample.pos, true
+		}
+		fraction := float32(t.Sub(sample.time)) / float32(newer.time.Sub(sample.time))
+		return sample.pos.Lerp(newer.pos, fraction), true
+	}
+	return st.Vector2{}, false
+}
+
+// returns the time of the latest sample, or false if nothing was recorded
+func (h *PositionHistory) LatestTime() (time.Time, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 {
+		return time.Time{}, false
+	}
+	return h.samples[(h.next-1+len(h.samples))%len(h.samples)].time, true
+}
+
+// forget all recorded positions, e.g. when the object is teleported
+func (h *PositionHistory) Clear() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.next = 0
+	h.count = 0
+}
@@ -0,0 +1,40 @@
+package states
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/structures"
+)
+
+func TestPositionHistoryRewind(t *testing.T) {
+	history := PositionHistory{}
+	start := time.Now()
+	if _, ok := history.At(start); ok {
+		t.Errorf("an empty history returned a position")
+	}
+
+	history.Record(start, structures.Vector2{X: 0, Y: 2})
+	history.Record(start.Add(100*time.Millisecond), structures.Vector2{X: 10, Y: 4})
+	if pos, ok := history.At(start.Add(25 * time.Millisecond)); !ok || pos != (structures.Vector2{X: 2.5, Y: 2.5}) {
+		t.Errorf("position between samples = %v, %t; want it interpolated", pos, ok)
+	}
+	if pos, ok := history.At(start.Add(time.Second)); !ok || pos.X != 10 {
+		t.Errorf("position after the latest sample = %v, %t; want the latest position", pos, ok)
+	}
+	if _, ok := history.At(start.Add(-time.Millisecond)); ok {
+		t.Errorf("a position was returned from before the history")
+	}
+
+	// old samples are dropped once the history is full
+	for i := 0; i < defs.PositionHistorySize; i++ {
+		history.Record(start.Add(time.Duration(200+i)*time.Millisecond), structures.Vector2{X: float32(i)})
+	}
+	if _, ok := history.At(start.Add(100 * time.Millisecond)); ok {
+		t.Errorf("a position was returned from a sample that should have been dropped")
+	}
+	if pos, ok := history.At(start.Add(200 * time.Millisecond)); !ok || pos.X != 0 {
+		t.Errorf("position at the oldest sample = %v, %t; want its position", pos, ok)
+	}
+}
@@ -1,7 +1,9 @@
 package states
 
 import (
+	"sort"
 	"sync"
+	"sync/atomic"
 
 	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/util"
 )
@@ -9,15 +11,77 @@ import (
 // Base struct for instances containing live players
 type RegisteredInstance struct {
 	ExpirableInstance
-	Players sync.Map `json:"Players"` // key: string; value: dummy flag (boolean)
-	HostID  string   `json:"HostID"`  // the id of the hosting player
+	Players    sync.Map    `json:"Players"`    // key: string; value: dummy flag (boolean)
+	Spectators sync.Map    `json:"Spectators"` // players who receive all updates but do not take part (key: string; value: dummy flag (boolean))
+	HostID     string      `json:"HostID"`     // the id of the hosting player
+	SnapshotHz int         `json:"SnapshotHz"` // the rate of batched snapshots sent to the instance; zero if player actions are relayed as they arrive
+	Chat       ChatHistory `json:"-"`          // the recent chat messages of the instance
+	bytesSent  atomic.Uint64
+	joinOrder  sync.Map      // the order in which players and spectators joined the instance, used to elect hosts (key: string; value: uint64)
+	joinCount  atomic.Uint64 // the number of joins recorded so far
 }
 
 // create a clone of the stored instance
 func (r *RegisteredInstance) Clone() *RegisteredInstance {
 	retVal := &RegisteredInstance{
-		Players: *util.CopySyncMap(&r.Players),
+		Players:    *util.CopySyncMap(&r.Players),
+		Spectators: *util.CopySyncMap(&r.Spectators),
+		HostID:     r.HostID,
+		SnapshotHz: r.SnapshotHz,
 	}
 	retVal.ExpirableInstance.LastUpdate = r.LastUpdate
+	retVal.joinOrder = *util.CopySyncMap(&r.joinOrder)
+	retVal.joinCount.Store(r.joinCount.Load())
 	return retVal
 }
+
+// count the bytes broadcast to the players of the instance
+func (r *RegisteredInstance) CountBytesSent(v uint64) {
+	r.bytesSent.Add(v)
+}
+
+// returns the number of bytes broadcast to the players of the instance
+func (r *RegisteredInstance) GetBytesSent() uint64 {
+	return r.bytesSent.Load()
+}
+
+// record that a player joined the instance; a player who is already in it keeps their place in the join order
+func (r *RegisteredInstance) RecordJoin(playerID string) {
+	if _, ok := r.joinOrder.Load(playerID); !ok {
+		r.joinOrder.LoadOrStore(playerID, r.joinCount.Add(1))
+	}
+}
+
+// forget the place of a player who left the instance in the join order
+func (r *RegisteredInstance) ForgetJoin(playerID string) {
+	r.joinOrder.Delete(playerID)
+}
+
+// returns the ids of the instance's players (not spectators) ordered by how long they have been in it, longest first
+// * players whose join was not recorded come last, ordered by id
+func (r *RegisteredInstance) PlayersByJoinOrder() []string {
+	type joined struct {
+		id    string
+		order uint64
+	}
+	players := []joined{}
+	r.Players.Range(func(pid, _ interface{}) bool {
+		order := ^uint64(0)
+		if v, ok := r.joinOrder.Load(pid); ok {
+			order = v.(uint64)
+		}
+		players = append(players, joined{id: pid.(string), order: order})
+		return true
+	})
+	sort.Slice(players, func(i, j int) bool {
+		if players[i].order != players[j].order {
+			return players[i].order < players[j].order
+		}
+		return players[i].id < players[j].id
+	})
+	ids := make([]string, len(players))
+	for i, p := range players {
+		ids[i] = p.id
+	}
+	return ids
+}
@@ -0,0 +1,35 @@
+package states
+
+import (
+	"slices"
+	"testing"
+)
+
+// check that players are listed by how long they have been in the instance, and that the order carries over to clones
+func TestPlayersByJoinOrder(t *testing.T) {
+	var r RegisteredInstance
+	for _, pid := range []string{"carol", "alice", "bob"} {
+		r.Players.Store(pid, true)
+		r.RecordJoin(pid)
+	}
+	r.RecordJoin("carol") // rejoining does not move a player to the back
+	r.Spectators.Store("spec", true)
+	r.RecordJoin("spec")
+	r.Players.Store("unrecorded", true)
+
+	want := []string{"carol", "alice", "bob", "unrecorded"}
+	if got := r.PlayersByJoinOrder(); !slices.Equal(got, want) {
+		t.Errorf("join order = %v; want %v", got, want)
+	}
+	if got := r.Clone().PlayersByJoinOrder(); !slices.Equal(got, want) {
+		t.Errorf("join order of clone = %v; want %v", got, want)
+	}
+
+	// a player who leaves and comes back goes to the back
+	r.ForgetJoin("carol")
+	r.RecordJoin("carol")
+	want = []string{"alice", "bob", "carol", "unrecorded"}
+	if got := r.PlayersByJoinOrder(); !slices.Equal(got, want) {
+		t.Errorf("join order after rejoining = %v; want %v", got, want)
+	}
+}
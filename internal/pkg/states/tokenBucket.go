@@ -0,0 +1,46 @@
+package states
+
+import (
+	"sync"
+	"time"
+)
+
+// limits how often something may happen, allowing short bursts
+// * the bucket holds up to a burst of tokens and refills at a steady rate; each event takes a token
+type TokenBucket struct {
+	tokens float64   // the tokens currently available
+	last   time.Time // the time the tokens were last refilled; zero if the bucket has never been used
+	mu     sync.Mutex
+}
+
+// take a token if one is available, after refilling one token per refill period up to the burst size; returns false if there is none
+func (b *TokenBucket) Allow(now time.Time, burst int, refill time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now, burst, refill)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// returns whether a token is available, without taking it
+func (b *TokenBucket) Available(now time.Time, burst int, refill time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now, burst, refill)
+	return b.tokens >= 1
+}
+
+// add the tokens earned since the bucket was last refilled, up to the burst size; must be called with the lock held
+func (b *TokenBucket) refill(now time.Time, burst int, refill time.Duration) {
+
+	// a new bucket starts full
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.tokens+float64(elapsed)/float64(refill), float64(burst))
+	}
+	b.last = now
+}
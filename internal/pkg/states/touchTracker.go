@@ -0,0 +1,84 @@
+package states
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// tracks the touches made on the ball by the team currently in possession of it
+type TouchTracker struct {
+	Team        int    // the team that is in possession of the ball
+	Count       int    // the number of touches made by the team in possession; zero if nobody has touched the ball since it last crossed the net
+	LastTouchBy string // the id of the player who made the most recent touch
+	mu          sync.Mutex
+}
+
+// a copy of the touches tracked for a possession, which may be restored to undo the touches made since
+type TouchSnapshot struct {
+	Team        int
+	Count       int
+	LastTouchBy string
+}
+
+// a touch accepted on the ball, kept so that a competing touch perceived earlier by another player can take its place
+type AcceptedTouch struct {
+	BallID        string        // the id of the ball that was touched
+	PlayerID      string        // the id of the player who touched it
+	TouchCount    int           // the touch count reported with the touch
+	PerceivedTime time.Time     // the time that the toucher's client perceived the ball at when they touched it
+	AcceptedAt    time.Time     // the time that the server received the touch
+	BallBefore    *BallState    // the ball as it was before the touch
+	TouchesBefore TouchSnapshot // the touches tracked before the touch
+}
+
+// register a touch by a player on the given team
+// * returns a description of the fault if the touch breaks the touch rules, or an empty string if it is legal
+func (t *TouchTracker) RegisterTouch(playerID string, team int, maxTouches int) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// a touch by the other team starts a new possession
+	if t.Count == 0 || t.Team != team {
+		t.Team = team
+		t.Count = 1
+		t.LastTouchBy = playerID
+		return ""
+	}
+
+	// otherwise check the touch against the rules
+	fault := ""
+	if t.LastTouchBy == playerID {
+		fault = fmt.Sprintf("double touch by player %s", playerID)
+	}
+	t.Count++
+	if t.Count > maxTouches {
+		fault = fmt.Sprintf("%d touches by team %d exceeds the limit of %d", t.Count, team, maxTouches)
+	}
+	t.LastTouchBy = playerID
+	return fault
+}
+
+// clear the touches, e.g. when the ball crosses the net or a new ball is put into play
+func (t *TouchTracker) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Count = 0
+	t.LastTouchBy = ""
+}
+
+// returns a copy of the tracked touches
+func (t *TouchTracker) Snapshot() TouchSnapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return TouchSnapshot{Team: t.Team, Count: t.Count, LastTouchBy: t.LastTouchBy}
+}
+
+// restore the tracked touches from a copy, undoing any touches registered since it was taken
+func (t *TouchTracker) Restore(snapshot TouchSnapshot) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Team = snapshot.Team
+	t.Count = snapshot.Count
+	t.LastTouchBy = snapshot.LastTouchBy
+}
@@ -0,0 +1,28 @@
+package states
+
+import (
+	"testing"
+)
+
+func TestTouchTrackerFaults(t *testing.T) {
+	tracker := TouchTracker{}
+	if fault := tracker.RegisterTouch("a", TeamLeft, 3); fault != "" {
+		t.Errorf("first touch faulted: %s", fault)
+	}
+	if fault := tracker.RegisterTouch("a", TeamLeft, 3); fault == "" {
+		t.Errorf("consecutive touch by the same player was not faulted")
+	}
+
+	tracker.Reset()
+	for i, pid := range []string{"a", "b", "a"} {
+		if fault := tracker.RegisterTouch(pid, TeamRight, 3); fault != "" {
+			t.Errorf("touch %d faulted: %s", i+1, fault)
+		}
+	}
+	if fault := tracker.RegisterTouch("b", TeamRight, 3); fault == "" {
+		t.Errorf("fourth touch was not faulted")
+	}
+	if fault := tracker.RegisterTouch("c", TeamLeft, 3); fault != "" {
+		t.Errorf("touch by the other team faulted: %s", fault)
+	}
+}
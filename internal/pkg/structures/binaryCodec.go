@@ -0,0 +1,228 @@
+package structures
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// A compact binary encoding for high-frequency messages, laid out as:
+// * the type name, as a length-prefixed string
+// * the request id, as a length-prefixed string (a single zero byte if there is none)
+// * the exported fields of the message in declaration order, with no field names:
+//   - bools as a single byte, signed integers as zigzag varints, unsigned integers as varints
+//   - float32 and float64 as fixed-width little-endian IEEE 754 values (so a Vector2 is always 8 bytes)
+//   - strings and slices as a varint length followed by their contents, arrays and structs as their elements in order
+//   - pointers as a presence byte followed by the value if present
+// * fields tagged `json:"-"` are skipped, matching the json encoding
+
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string {
+	return CodecNameBinary
+}
+
+func (binaryCodec) IsBinary() bool {
+	return true
+}
+
+func (binaryCodec) Encode(b any, requestID string) ([]byte, error) {
+	v := reflect.ValueOf(b)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil, fmt.Errorf("cannot encode nil %s", v.Type())
+		}
+		v = v.Elem()
+	}
+	buf := appendString(nil, TypeName(b))
+	buf = appendString(buf, requestID)
+	return appendValue(buf, v)
+}
+
+func (binaryCodec) Decode(b any, data []byte) error {
+	v := reflect.ValueOf(b)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("cannot decode into non-pointer %T", b)
+	}
+	typeName, rest, err := readString(data)
+	if err != nil {
+		return fmt.Errorf("error reading type of binary message: %w", err)
+	}
+	if expected := TypeName(b); !SameTypeName(typeName, expected) {
+		return fmt.Errorf("binary message of type %s cannot be decoded into %s", typeName, expected)
+	}
+	_, rest, err = readString(rest)
+	if err != nil {
+		return fmt.Errorf("error reading request id of binary message: %w", err)
+	}
+	rest, err = readValue(rest, v.Elem())
+	if err != nil {
+		return fmt.Errorf("error reading binary message of type %s: %w", typeName, err)
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("binary message of type %s has %d unexpected trailing bytes", typeName, len(rest))
+	}
+	return nil
+}
+
+func (binaryCodec) Header(data []byte) (MessageHeader, error) {
+	typeName, rest, err := readString(data)
+	if err != nil {
+		return MessageHeader{}, err
+	}
+	requestID, _, err := readString(rest)
+	if err != nil {
+		return MessageHeader{}, err
+	}
+	return MessageHeader{Type: typeName, RequestID: requestID}, nil
+}
+
+// returns whether a struct field is part of the encoding
+func isEncodedField(f reflect.StructField) bool {
+	return f.IsExported() && strings.Split(f.Tag.Get("json"), ",")[0] != "-"
+}
+
+// append the binary encoding of a value to the buffer
+func appendValue(buf []byte, v reflect.Value) ([]byte, error) {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return binary.AppendVarint(buf, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return binary.AppendUvarint(buf, v.Uint()), nil
+	case reflect.Float32:
+		return binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(v.Float()))), nil
+	case reflect.Float64:
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(v.Float())), nil
+	case reflect.String:
+		return appendString(buf, v.String()), nil
+	case reflect.Pointer:
+		if v.IsNil() {
+			return append(buf, 0), nil
+		}
+		return appendValue(append(buf, 1), v.Elem())
+	case reflect.Slice:
+		buf = binary.AppendUvarint(buf, uint64(v.Len()))
+		fallthrough
+	case reflect.Array:
+		var err error
+		for i := 0; i < v.Len() && err == nil; i++ {
+			buf, err = appendValue(buf, v.Index(i))
+		}
+		return buf, err
+	case reflect.Struct:
+		var err error
+		for i := 0; i < v.NumField() && err == nil; i++ {
+			if isEncodedField(v.Type().Field(i)) {
+				buf, err = appendValue(buf, v.Field(i))
+			}
+		}
+		return buf, err
+	default:
+		return nil, fmt.Errorf("unsupported type for binary encoding: %s", v.Type())
+	}
+}
+
+// read the binary encoding of a value from the data into v, and return the remaining data
+func readValue(data []byte, v reflect.Value) ([]byte, error) {
+	switch v.Kind() {
+	case reflect.Bool:
+		if len(data) < 1 {
+			return nil, fmt.Errorf("unexpected end of data reading %s", v.Type())
+		}
+		v.SetBool(data[0] != 0)
+		return data[1:], nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, size := binary.Varint(data)
+		if size <= 0 {
+			return nil, fmt.Errorf("invalid varint reading %s", v.Type())
+		}
+		v.SetInt(n)
+		return data[size:], nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, size := binary.Uvarint(data)
+		if size <= 0 {
+			return nil, fmt.Errorf("invalid varint reading %s", v.Type())
+		}
+		v.SetUint(n)
+		return data[size:], nil
+	case reflect.Float32:
+		if len(data) < 4 {
+			return nil, fmt.Errorf("unexpected end of data reading %s", v.Type())
+		}
+		v.SetFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(data))))
+		return data[4:], nil
+	case reflect.Float64:
+		if len(data) < 8 {
+			return nil, fmt.Errorf("unexpected end of data reading %s", v.Type())
+		}
+		v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(data)))
+		return data[8:], nil
+	case reflect.String:
+		str, rest, err := readString(data)
+		if err != nil {
+			return nil, err
+		}
+		v.SetString(str)
+		return rest, nil
+	case reflect.Pointer:
+		if len(data) < 1 {
+			return nil, fmt.Errorf("unexpected end of data reading %s", v.Type())
+		}
+		if data[0] == 0 {
+			v.SetZero()
+			return data[1:], nil
+		}
+		v.Set(reflect.New(v.Type().Elem()))
+		return readValue(data[1:], v.Elem())
+	case reflect.Slice:
+		n, size := binary.Uvarint(data)
+		if size <= 0 || n > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid length reading %s", v.Type())
+		}
+		data = data[size:]
+		if n == 0 {
+			v.SetZero()
+			return data, nil
+		}
+		v.Set(reflect.MakeSlice(v.Type(), int(n), int(n)))
+		fallthrough
+	case reflect.Array:
+		var err error
+		for i := 0; i < v.Len() && err == nil; i++ {
+			data, err = readValue(data, v.Index(i))
+		}
+		return data, err
+	case reflect.Struct:
+		var err error
+		for i := 0; i < v.NumField() && err == nil; i++ {
+			if isEncodedField(v.Type().Field(i)) {
+				data, err = readValue(data, v.Field(i))
+			}
+		}
+		return data, err
+	default:
+		return nil, fmt.Errorf("unsupported type for binary encoding: %s", v.Type())
+	}
+}
+
+// append a length-prefixed string to the buffer
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// read a length-prefixed string from the data, and return the remaining data
+func readString(data []byte) (string, []byte, error) {
+	n, size := binary.Uvarint(data)
+	if size <= 0 || uint64(len(data)-size) < n {
+		return "", nil, fmt.Errorf("invalid string length")
+	}
+	return string(data[size : size+int(n)]), data[size+int(n):], nil
+}
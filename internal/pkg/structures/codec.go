@@ -0,0 +1,73 @@
+package structures
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// a codec encodes messages exchanged with clients, wrapping each message along with its type name so that the receiver knows how to decode it
+// * every connection negotiates the codec it uses, so the rest of the server stays agnostic of the encoding
+type Codec interface {
+	Name() string                                   // the name used to select the codec when negotiating with a client
+	IsBinary() bool                                 // whether encoded messages are sent as binary (rather than text) frames
+	Encode(b any, requestID string) ([]byte, error) // wrap a message, along with the id of the request it answers (if any), and encode it
+	Decode(b any, data []byte) error                // decode a wrapped message into the object b points to
+	Header(data []byte) (MessageHeader, error)      // read the header of a wrapped message without decoding its contents
+}
+
+// the information that a wrapped message carries besides its contents
+type MessageHeader struct {
+	Type      string // the type name of the message
+	RequestID string // the id chosen by the client to match a response to its request; empty if none
+}
+
+// names of the supported codecs
+const (
+	CodecNameJSON   = "json"
+	CodecNameBinary = "binary"
+)
+
+// the available codecs
+var (
+	JSONCodec   Codec = jsonCodec{}
+	BinaryCodec Codec = binaryCodec{}
+)
+
+// returns the codec with the given name, or false if it is not supported
+func CodecByName(name string) (Codec, bool) {
+	switch strings.ToLower(name) {
+	case "", CodecNameJSON:
+		return JSONCodec, true
+	case CodecNameBinary:
+		return BinaryCodec, true
+	default:
+		return nil, false
+	}
+}
+
+// the original text encoding, where a WrappedMessage holds the type name and the message as a json string
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return CodecNameJSON
+}
+
+func (jsonCodec) IsBinary() bool {
+	return false
+}
+
+func (jsonCodec) Encode(b any, requestID string) ([]byte, error) {
+	return ToWrappedJSONReply(b, requestID)
+}
+
+func (jsonCodec) Decode(b any, data []byte) error {
+	return FromWrappedJSON(b, data)
+}
+
+func (jsonCodec) Header(data []byte) (MessageHeader, error) {
+	var wm WrappedMessage
+	if err := json.Unmarshal(data, &wm); err != nil {
+		return MessageHeader{}, err
+	}
+	return MessageHeader{Type: wm.Type, RequestID: wm.RequestID}, nil
+}
@@ -4,17 +4,24 @@ import (
 	"encoding/json"
 	"log"
 	"reflect"
+	"strings"
 	"testing"
 )
 
 // wrap a serializable object in a WrappedMessage container and return it in json format
 func ToWrappedJSON(b any) ([]byte, error) {
+	return ToWrappedJSONReply(b, "")
+}
+
+// wrap a serializable object in a WrappedMessage container that answers the request with the given id, and return it in json format
+func ToWrappedJSONReply(b any, requestID string) ([]byte, error) {
 	data, err := json.Marshal(b)
 	if err != nil {
 		log.Println(err.Error())
 		return nil, err
 	}
-	wm := NewWrappedMessage(reflect.TypeOf(b).String(), string(data))
+	wm := NewWrappedMessage(TypeName(b), string(data))
+	wm.RequestID = requestID
 	msg, err := json.Marshal(wm)
 	if err != nil {
 		return nil, err
@@ -22,6 +29,26 @@ func ToWrappedJSON(b any) ([]byte, error) {
 	return msg, nil
 }
 
+// returns the type name that a message is wrapped with, regardless of whether it is passed by value or by pointer
+func TypeName(b any) string {
+	t := reflect.TypeOf(b)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil {
+		return ""
+	}
+	return t.String()
+}
+
+// returns whether two type names refer to the same message type, ignoring their namespaces and case (so that client type names such as "PaperVolleyball.PingMessage" match "messages.PingMessage")
+func SameTypeName(a string, b string) bool {
+	unqualified := func(name string) string {
+		return name[strings.LastIndexAny(name, ".+")+1:]
+	}
+	return strings.EqualFold(unqualified(a), unqualified(b))
+}
+
 // from json string of a wrapped message, construct the appropriate object
 func FromWrappedJSON(b any, jsonData []byte) error {
 
@@ -61,3 +88,30 @@ func CompareSerializeDeserialize[T any](t *testing.T, original T, getField func(
 		t.Errorf("Serialize %T = %s; want %s", original, getField(copy), getField(original))
 	}
 }
+
+// Generalized test function for a full round trip of a message through a codec
+func CompareCodecRoundTrip[T any](t *testing.T, codec Codec, original T) {
+	// Encode the message
+	const requestID = "request-42"
+	msg, err := codec.Encode(original, requestID)
+	if err != nil {
+		t.Fatalf("Error encoding %T with %s codec: %v", original, codec.Name(), err)
+	}
+
+	// Check that the header can be read back without decoding
+	header, err := codec.Header(msg)
+	if err != nil || header.Type != TypeName(original) || header.RequestID != requestID {
+		t.Errorf("Header of %T encoded with %s codec = %+v (%v); want type %s and request id %s", original, codec.Name(), header, err, TypeName(original), requestID)
+	}
+
+	// Decode it again
+	var copy T
+	if err := codec.Decode(&copy, msg); err != nil {
+		t.Fatalf("Error decoding %T with %s codec: %v", original, codec.Name(), err)
+	}
+
+	// Check that nothing was lost
+	if !reflect.DeepEqual(original, copy) {
+		t.Errorf("Round trip of %T with %s codec = %+v; want %+v", original, codec.Name(), copy, original)
+	}
+}
@@ -1,7 +1,21 @@
 package structures
 
+import (
+	"math"
+)
+
 // a primitive data structure for storing coordinate pairs
 type Vector2 struct {
 	X float32 `json:"X"`
 	Y float32 `json:"Y"`
 }
+
+// returns the distance between two points
+func (v Vector2) Distance(o Vector2) float32 {
+	return float32(math.Hypot(float64(v.X-o.X), float64(v.Y-o.Y)))
+}
+
+// returns the point a fraction t of the way from v to o
+func (v Vector2) Lerp(o Vector2, t float32) Vector2 {
+	return Vector2{X: v.X + (o.X-v.X)*t, Y: v.Y + (o.Y-v.Y)*t}
+}
@@ -1,9 +1,11 @@
 package structures
 
 // a container for a json message string, which also includes a specifier `Type` to communicate what type of data is stored, and the gameID that it is relevant to
+// * a client may tag a request with a `RequestID` of its choosing, which the server echoes on its response so that the two can be matched up
 type WrappedMessage struct {
-	Type string `json:"Type"`
-	Data string `json:"Data"`
+	Type      string `json:"Type"`
+	Data      string `json:"Data"`
+	RequestID string `json:"RequestID,omitempty"`
 }
 
 // constructor
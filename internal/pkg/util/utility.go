@@ -1,6 +1,8 @@
 package util
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"sync"
@@ -63,3 +65,12 @@ func HTTPHeaderSize(header http.Header) uint64 {
 	headerSize += 2
 	return uint64(headerSize)
 }
+
+// returns a random hex string generated from the specified number of bytes, suitable for use as a secret token
+func RandomToken(numBytes int) string {
+	b := make([]byte, numBytes)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("unable to generate random token: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
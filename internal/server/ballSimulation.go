@@ -0,0 +1,153 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/messages"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/states"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/structures"
+)
+
+// This file contains the server-authoritative simulation of the game ball
+// * Clients report touches on the ball, but its trajectory, net contact and landing are decided here
+
+// integrate the game's ball on a fixed tick until the game is removed from the server
+func (s *ServerData) simulateBall(game *states.GameState) {
+	dt := float32(1.0 / float64(defs.BallTickRate))
+	ticker := time.NewTicker(time.Second / defs.BallTickRate)
+	defer ticker.Stop()
+
+	tick := 0
+	for range ticker.C {
+
+		// stop simulating once the game no longer exists
+		if g, err := s.FindGame(game.GUID); err != nil || g != game {
+			log.Printf("Stopping ball simulation for game %s", game.GUID)
+			return
+		}
+
+		// advance the ball, if there is one in play
+		ball, result := game.StepBall(dt)
+		if ball == nil {
+			continue
+		}
+		tick++
+
+		// resolve the outcome of the step
+		if result.CrossedNet {
+			game.Touches.Reset()
+		}
+		if result.Landed {
+			s.processBallDeath(game, ball, result)
+		} else if result.HitNet || (tick%defs.BallSnapshotTicks == 0 && game.SnapshotHz == 0) {
+			s.broadcastBallState(game, ball) // games with a snapshot rate get the ball's trajectory in their snapshots
+		}
+	}
+}
+
+// handle the transition of the game ball from alive to dead, as decided by the server
+func (s *ServerData) processBallDeath(game *states.GameState, ball *states.BallState, result states.BallStepResult) {
+	log.Printf("Game ball %s landed at x=%.2f in game %s (out of bounds: %t)", ball.GUID, ball.Pos.X, game.GUID, result.OutOfBounds)
+	s.broadcastBallState(game, ball)
+
+	// award the rally to the team that won it
+	s.awardRally(game, s.computeRallyWinner(ball, result))
+}
+
+// returns the time that a player's client perceived the game at when it sent a message, by rewinding from its arrival by half of the player's round trip time, up to the rewind window
+func perceivedTime(received time.Time, player *states.PlayerState, maxRewindMs int) time.Time {
+	if player == nil {
+		return received
+	}
+	rtt, _ := player.Latency.RTT()
+	rewindMs := min(rtt/2, float64(maxRewindMs))
+	return received.Add(-time.Duration(rewindMs * float64(time.Millisecond)))
+}
+
+// check that a touch on the ball was plausible at the time its client perceived the ball, and return the reason if it was not
+// * the ball must have been near the touch, going by where the server had the ball at the perceived time
+// * the player must have been within reach of the touch
+func checkTouchReach(game *states.GameState, toucher *states.PlayerState, ball *states.BallState, perceived time.Time, received time.Time) string {
+	if serverPos, ok := game.BallHistory.At(perceived); ok {
+		if dist := serverPos.Distance(ball.Pos); dist > defs.TouchPosTolerance {
+			return fmt.Sprintf("Ball was %.2f away from the touch at the time it was made", dist)
+		}
+	}
+	return checkPlayerReach(toucher, ball.Pos, received)
+}
+
+// check that a player was within reach of a touch at the given position, and return the reason if they were not
+// * their position at the time is the one that arrived along with the touch, since both come over the same connection
+func checkPlayerReach(toucher *states.PlayerState, pos structures.Vector2, received time.Time) string {
+	if playerPos, ok := toucher.PosHistory.At(received); ok {
+		if dist := playerPos.Distance(pos); dist > defs.MaxTouchReach {
+			return fmt.Sprintf("Player was %.2f away from the touch, out of reach", dist)
+		}
+	}
+	return ""
+}
+
+// returns the position that a serve by the given player starts from, going by the server's own copy of the player's position
+func servePosition(player *states.PlayerState) structures.Vector2 {
+	pos := player.Pos
+	pos.Y += defs.ServeHeight
+	return pos
+}
+
+// register a touch on the ball by a player of the game against the touch rules of the game
+// * returns the team of the touching player and a description of the fault if the touch broke the rules
+func (s *ServerData) registerTouch(game *states.GameState, toucher *states.PlayerState) (int, string) {
+	if game.Match.Rules.DisableTouchRules {
+		return 0, ""
+	}
+	team := states.TeamAtPosX(toucher.Pos.X)
+	return team, game.Touches.RegisterTouch(toucher.GUID, team, game.Match.Rules.MaxTouches)
+}
+
+// end the rally due to a fault by the given team, killing the ball and awarding the rally to the opponents
+func (s *ServerData) faultBall(game *states.GameState, ballID string, faultingTeam int, reason string) {
+	ball := game.KillBall(ballID)
+	if ball == nil {
+		return
+	}
+	log.Printf("Fault by team %d in game %s: %s", faultingTeam, game.GUID, reason)
+	s.broadcastBallState(game, ball)
+	s.awardRally(game, states.OpposingTeam(faultingTeam))
+}
+
+// determine which team won the rally given the ball's landing
+// * a ball landing in the court is a point for the team on the other side
+// * a ball landing out of bounds is a point against the team that touched it last, or against the side that it was sent from if no touch is known
+func (s *ServerData) computeRallyWinner(ball *states.BallState, result states.BallStepResult) int {
+	landedSide := states.TeamAtPosX(ball.Pos.X)
+	if !result.OutOfBounds {
+		return states.OpposingTeam(landedSide)
+	}
+	if toucher, err := s.FindPlayer(ball.TouchedBy); err == nil {
+		return states.OpposingTeam(states.TeamAtPosX(toucher.Pos.X))
+	}
+	return landedSide
+}
+
+// award a rally to a team in the game and broadcast the new score to all players, along with the result of the match if it has ended
+func (s *ServerData) awardRally(game *states.GameState, team int) {
+	outcome, ok := game.Match.AwardRally(team)
+	if !ok {
+		return
+	}
+	score := game.Match.Score()
+	log.Printf("Rally awarded to team %d in game %s; score %d-%d, sets %d-%d", team, game.GUID, score.Points[states.TeamLeft], score.Points[states.TeamRight], score.SetsWon[states.TeamLeft], score.SetsWon[states.TeamRight])
+	s.broadcastScore(game)
+
+	if outcome.MatchEnded {
+		log.Printf("Match ended in game %s, won by team %d", game.GUID, score.WinningTeam)
+		s.broadcastws(messages.MatchEndMessage{
+			WinningTeam: score.WinningTeam,
+			Score:       score,
+			GameID:      game.GUID,
+		}, &game.RegisteredInstance)
+	}
+}
@@ -1,160 +1,122 @@
 package server
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
-	"net"
 	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/filter"
 	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/messages"
 	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/states"
 	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/structures"
-
-	"github.com/gorilla/websocket"
 )
 
 // This file contains the engine logic for processing game requests
 // * It defines all handlers of messages received from the client, and serves as a function directory for the differents types of messages that can be received
 // * Helper functions are contained in a separate file
 
+// a message received from a client, along with the session it came in on and the codec it was encoded with
+type inbound struct {
+	sess      *Session
+	codec     structures.Codec
+	body      []byte
+	requestID string    // the id that the client tagged the message with, to be echoed on the response
+	received  time.Time // the time the message was read from the connection
+}
+
+// decode the received message into the specified message object
+func (in *inbound) decode(msg any) error {
+	return in.codec.Decode(msg, in.body)
+}
+
+// returns the time the message was read from the connection, or now if it is not known
+func (in *inbound) receivedAt() time.Time {
+	if in.received.IsZero() {
+		return time.Now()
+	}
+	return in.received
+}
+
 // process an message containing information about an in-game event, and returns a message to send back
-func (s *ServerData) processws(conn *websocket.Conn, msgBody []byte) ([]byte, error) {
+// * if the message can't be processed, the error is returned along with a message that reports it back to the client
+func (s *ServerData) processws(in *inbound) (any, error) {
 
-	// deserialize the message
-	var data map[string]interface{}
-	err := json.Unmarshal(msgBody, &data)
+	// determine what type of data was passed in
+	header, err := in.codec.Header(in.body)
 	if err != nil {
 		fmt.Println("Error parsing incoming message: ", err)
-		return nil, err
+		return newErrorMessage(requestErrorf(messages.ErrCodeBadRequest, "unable to read message: %s", err), ""), err
 	}
+	in.requestID = header.RequestID
 
-	// search for the "type" key-value pair to determine what type of data was pased in
-	const jsonTagType string = "type"
-	typeVal := ""
-	for key := range data {
-		val := data[key].(string)
-		if strings.Contains(strings.ToLower(key), jsonTagType) {
-			typeVal = strings.ToLower(val)
-		}
-	}
-	if len(typeVal) == 0 {
-		return nil, fmt.Errorf("error finding type key in json string; unidentifiable message")
+	// look up the handler registered for the type, and let the client know if there is none
+	entry, ok := lookupMessageType(header.Type)
+	if !ok {
+		err := requestErrorf(messages.ErrCodeUnknownType, "Unknown message type: %s", header.Type)
+		return newErrorMessage(err, header.Type), err
 	}
 
-	// read the wrapped data and direct to the processing function
-	if strings.Contains(typeVal, JsonTagPingMsg) {
-
-		// handle ping request
-		return handleping(msgBody)
-
-	} else if strings.Contains(typeVal, JsonTagCreateGameMsg) {
-
-		// create game request
-		return s.handlecreategame()
-
-	} else if strings.Contains(typeVal, JsonTagCreateLobbyMsg) {
-
-		// create lobby request
-		return s.handlecreatelobby()
-
-	} else if strings.Contains(typeVal, JsonTagAdmissionMsg) {
-
-		// register a client to the server
-		return s.handleadmitplayer(conn.RemoteAddr(), msgBody)
-
-	} else if strings.Contains(typeVal, JsonTagAddPlayerMsg) {
-
-		// add player to game request
-		return s.handleaddplayergame(conn, msgBody)
-
-	} else if strings.Contains(typeVal, JsonTagAddPlayerLobby) {
-
-		// add player to lobby request
-		return s.handleaddplayerlobby(conn, msgBody)
-
-	} else if strings.Contains(typeVal, JsonTagRemPlayerLobby) {
-
-		// remove player from lobby request
-		return s.handleleavelobby(msgBody)
-
-	} else if strings.Contains(typeVal, JsonTagRemPlayerGame) {
-
-		// remove player from game request
-		return s.handleleavegame(msgBody)
-
-	} else if strings.Contains(typeVal, JsonTagSetBackdrop) {
-
-		// set the backdrop resource name
-		return s.handlesetbackdrop(msgBody)
-
-	} else if strings.Contains(typeVal, JsonTagCheckLobbyMsg) {
-
-		// check if a room code exists
-		return s.handlechecklobby(msgBody)
-
-	} else if strings.Contains(typeVal, JsonTagSwitchMsg) {
-
-		// switch player to other side request
-		return s.handleswitch(msgBody)
-
-	} else if strings.Contains(typeVal, JsonTagPlayerEvent) {
-
-		// player update, just rebroadcast the same message but to all connected clients of the corresponding game
-		return s.handleplayeraction(msgBody)
-
-	} else if strings.Contains(typeVal, JsonTagBallEvent) {
-
-		// ball update, check whether it is a valid hit or something else happened to the ball already
-		return s.handleballevent(msgBody)
-
-	} else {
-		return nil, fmt.Errorf("unrecognized json tag in received data; unidentifiable message")
+	// decode the message and direct it to the processing function
+	res, err := entry.handle(s, in)
+	if err != nil {
+		return newErrorMessage(err, header.Type), err
 	}
+	return res, nil
 }
 
 // process a ping request
-func handleping(msgBody []byte) ([]byte, error) {
+func (s *ServerData) handleping(in *inbound, rq messages.PingMessage) (any, error) {
 
-	// deserialize the message
-	var rq messages.PingMessage
-	structures.FromWrappedJSON(&rq, msgBody)
+	// stamp the time that the ping arrived
+	rq.ServerRecvTime = in.receivedAt().UnixMilli()
 
-	// re-serialize the message
-	return structures.ToWrappedJSON(rq)
-}
-
-// process a game creation request
-func (s *ServerData) handlecreategame() ([]byte, error) {
-
-	// create a game in the data
-	game := *states.NewGameState()
-	s.Games.LoadOrStore(game.GUID, &game)
-
-	// start a routine that times the game out if too much time has passed since it last updated
-	checkTimeout := func(g *states.GameState) {
-		for g != nil {
-			if g.RegisteredInstance.IsTimeoutExpired() {
-				log.Printf("Deleting game %s due to timeout", g.GUID)
-				s.Games.CompareAndDelete(g.GUID, g)
-				break
-			}
-			time.Sleep(time.Minute) // sleep for some time to prevent high CPU usage and avoid tight looping
+	// measure the player's round trip from the echo of their previous reply, if the ping is made on behalf of a player on this connection
+	// * the echo must be of the latest reply actually sent on this connection, so that a client can't make up the time its round trip is measured from
+	var player *states.PlayerState
+	if len(rq.ServerPlayerID) > 0 {
+		var err error
+		player, err = s.FindSessionPlayer(in.sess, rq.ServerPlayerID)
+		if err != nil {
+			return nil, err
+		}
+		if in.sess.takePingReply(rq.PrevServerSendTime) {
+			player.Latency.Measure(rq.PrevServerSendTime, rq.PrevClientRecvTime, rq.ClientSendTime, rq.ServerRecvTime)
 		}
+		rq.RTTMs, rq.JitterMs = player.Latency.RTT()
+	}
+
+	// stamp the time of the reply, and remember it to match the echo on the next ping
+	rq.ServerSendTime = time.Now().UnixMilli()
+	in.sess.recordPingReply(rq.ServerSendTime)
+	if player != nil {
+		player.Latency.ReplySent(rq.ServerSendTime)
 	}
-	go checkTimeout(&game)
+	return rq, nil
+}
 
-	// create message to send back, with the game ID
-	rq := messages.CreateGameMessage{
-		GameID: game.GUID,
+// process a game creation request
+func (s *ServerData) handlecreategame(in *inbound, rq messages.CreateGameMessage) (any, error) {
+
+	// create a game in the data, with the requested match rules and snapshot rate
+	game := states.NewGameState()
+	game.Match = states.NewMatchState(rq.Rules)
+	game.SnapshotHz = clampSnapshotHz(rq.SnapshotHz)
+	s.registerGame(game)
+
+	// create message to send back, with the game ID and the settings in effect
+	retrq := messages.CreateGameMessage{
+		GameID:     game.GUID,
+		Rules:      game.Match.Rules,
+		SnapshotHz: game.SnapshotHz,
 	}
-	msg, err := structures.ToWrappedJSON(rq)
-	return msg, err
+	return retrq, nil
 }
 
 // process a lobby creation request
-func (s *ServerData) handlecreatelobby() ([]byte, error) {
+func (s *ServerData) handlecreatelobby(in *inbound, request messages.CreateLobbyMessage) (any, error) {
 
 	// prepare message
 	rq := messages.CreateLobbyMessage{}
@@ -167,13 +129,17 @@ func (s *ServerData) handlecreatelobby() ([]byte, error) {
 		log.Println(errMsg)
 	} else {
 		rq.RoomCode = lobby.RoomCode
+		lobby.SnapshotHz = clampSnapshotHz(request.SnapshotHz)
+		rq.SnapshotHz = lobby.SnapshotHz
+		lobby.IsPublic = request.IsPublic
+		rq.IsPublic = lobby.IsPublic
 		s.Lobbies.Store(lobby.RoomCode, lobby)
 		log.Printf("Succesfully registered a lobby with room code {%s}", lobby.RoomCode)
 
 		// start a routine that times the lobby out if too much time has passed since it last updated
 		checkTimeout := func(l *states.LobbyState) {
 			for l != nil {
-				if l.RegisteredInstance.IsTimeoutExpired() {
+				if l.RegisteredInstance.IsTimeoutExpired() && !s.isLobbyInMatch(l) {
 					log.Printf("Deleting lobby %s due to timeout", l.RoomCode)
 					s.Lobbies.CompareAndDelete(l.RoomCode, l)
 					break
@@ -182,65 +148,176 @@ func (s *ServerData) handlecreatelobby() ([]byte, error) {
 			}
 		}
 		go checkTimeout(lobby)
+
+		// start sending snapshots of the lobby, if it has a snapshot rate; they are paused while its players are in a match
+		go s.runSnapshots(snapshotSource{
+			r:        &lobby.RegisteredInstance,
+			roomCode: lobby.RoomCode,
+			exists: func() bool {
+				l, err := s.FindLobby(lobby.RoomCode)
+				return err == nil && l == lobby
+			},
+			paused: func() bool { return s.isLobbyInMatch(lobby) },
+		})
 	}
 
 	// return message with the lobby ID or containing the error message
-	msg, err := structures.ToWrappedJSON(rq)
-	return msg, err
+	return rq, nil
 }
 
 // initialize a client's data on the server and return their id to the client for communication
-func (s *ServerData) handleadmitplayer(addr net.Addr, msgBody []byte) ([]byte, error) {
+func (s *ServerData) handleadmitplayer(in *inbound, rq messages.AdmissionMessage) (any, error) {
+
+	// switch the connection over to the encoding requested by the client
+	codec, ok := structures.CodecByName(rq.Encoding)
+	if !ok {
+		return messages.AdmissionMessage{
+			ErrMsg:         fmt.Sprintf("Unsupported encoding: %s", rq.Encoding),
+			ClientPlayerID: rq.ClientPlayerID,
+		}, nil
+	}
+	in.sess.SetCodec(codec)
+
+	// resume an existing player if the client presented a resume token
+	if len(rq.ResumeToken) > 0 {
+		return s.resumePlayer(in, rq)
+	}
 
-	// decode the message body to get player's attributes
-	var rq messages.AdmissionMessage
-	structures.FromWrappedJSON(&rq, msgBody)
-	inputAttributes := rq.Attributes
+	// check the player's attributes against the server's policy
+	attributes, err := s.AttributePolicy.Validate(rq.Attributes)
+	if err != nil {
+		return messages.AdmissionMessage{
+			ErrMsg:         fmt.Sprintf("Invalid attributes: %s", err),
+			ClientPlayerID: rq.ClientPlayerID,
+			Encoding:       codec.Name(),
+		}, nil
+	}
+
+	// keep blocked words out of the display name that is shown to everyone
+	if s.WordFilter != nil {
+		name, ok := filter.Apply(s.WordFilter, s.NamePolicy, attributes.DisplayName, generateGuestName)
+		if !ok {
+			return messages.AdmissionMessage{
+				ErrMsg:         "Display name is not allowed",
+				ClientPlayerID: rq.ClientPlayerID,
+				Encoding:       codec.Name(),
+			}, nil
+		}
+		attributes.DisplayName = name
+	}
 
 	// create a new player on the server's player map
-	newPlayer := states.NewPlayer(addr)
-	newPlayer.PlayerAttributes = inputAttributes
+	newPlayer := states.NewPlayer(in.sess.ID)
+	newPlayer.PlayerAttributes = attributes
 	s.Players.LoadOrStore(newPlayer.GUID, newPlayer)
+	token := s.issueResumeToken(newPlayer)
 
 	// return message with the player's ID or containing the error message
 	retrq := messages.AdmissionMessage{
 		ClientPlayerID: rq.ClientPlayerID,
 		ServerPlayerID: newPlayer.GUID,
+		ResumeToken:    token,
+		Encoding:       codec.Name(),
 	}
-	msg, err := structures.ToWrappedJSON(retrq)
-	return msg, err
+	return retrq, nil
 }
 
-// process a player add to game request
-func (s *ServerData) handleaddplayergame(conn *websocket.Conn, msgBody []byte) ([]byte, error) {
+// reattach a disconnected player to a new connection using their resume token, and send them the full state of their game or lobby
+func (s *ServerData) resumePlayer(in *inbound, rq messages.AdmissionMessage) (any, error) {
+	sess := in.sess
 
-	// deserialize the message
-	var rq messages.AddPlayerGameMessage
-	structures.FromWrappedJSON(&rq, msgBody)
+	// find the player that the token belongs to
+	player, err := s.FindPlayerByResumeToken(rq.ResumeToken)
+	if err != nil {
+		log.Printf("[%s] Failed to resume session: %s", sess, err)
+		return messages.AdmissionMessage{
+			ErrMsg:         "The session could not be resumed; it may have expired.",
+			ClientPlayerID: rq.ClientPlayerID,
+		}, nil
+	}
+
+	// attach them to this connection, with a fresh token for next time
+	player.Reattach(sess.ID)
+	player.UpdateTime()
+	token := s.issueResumeToken(player)
+	log.Printf("[%s] Resumed session of player %s", sess, player.GUID)
+
+	// respond with their id before sending the state they missed
+	s.replyws(sess, messages.AdmissionMessage{
+		ClientPlayerID: rq.ClientPlayerID,
+		ServerPlayerID: player.GUID,
+		ResumeToken:    token,
+		Attributes:     player.PlayerAttributes,
+		Encoding:       sess.Codec().Name(),
+	}, in.requestID)
+	s.resyncPlayer(sess, player)
+	return nil, nil
+}
+
+// process a player add to game request
+func (s *ServerData) handleaddplayergame(in *inbound, rq messages.AddPlayerGameMessage) (any, error) {
 
 	// decode the message body
 	serverPlayerID := rq.ServerPlayerID
 	gameID := rq.GameID
 
-	// find the player's ID on the player map
-	player, pErr := s.FindPlayer(serverPlayerID)
-	if pErr != nil {
-		return nil, fmt.Errorf("could not find player id in registry: %s", serverPlayerID)
+	// find the player on this connection; players can only add themselves
+	player, err := s.FindSessionPlayer(in.sess, serverPlayerID)
+	if err != nil {
+		return nil, err
 	}
-	player.GameID = gameID
-	player.UpdateTime()
 
 	// find the game's ID on the game map
 	game, gErr := s.FindGame(gameID)
 	if gErr != nil {
-		return nil, fmt.Errorf("could not find game id in registry: %s", gameID)
+		return nil, requestErrorf(messages.ErrCodeGameNotFound, "could not find game id in registry: %s", gameID)
+	}
+
+	// a match started from a lobby is subject to the lobby's bans and lock, so that it can't be used to get around them
+	if lobby, err := s.FindLobby(game.RoomCode); err == nil && lobby.GameID == gameID {
+		if err := checkLobbyAdmission(lobby, player, in.sess, "", ""); err != nil {
+			return nil, err
+		}
 	}
+	player.GameID = gameID
+	player.UpdateTime()
+
+	// spectators only need to be sent the current state of the game
+	if rq.Spectate {
+		player.IsSpectator = true
+		s.sendGamePlayerIncludes(in.sess, &game.RegisteredInstance)
+		s.sendCurrentScore(in.sess, game)
+		game.Players.Delete(serverPlayerID)
+		game.Spectators.LoadOrStore(serverPlayerID, true)
+		game.RecordJoin(serverPlayerID)
+		game.UpdateTime()
 
-	// send back existing players
-	s.sendGamePlayerIncludes(conn, &game.RegisteredInstance)
+		// a host who moves off the court to watch hands the host role over to one of the players
+		s.assignHostIfLeave(&game.RegisteredInstance, serverPlayerID)
+		return rq, nil
+	}
 
-	// store the new player
+	// autoassign them to a team and a position on the court, if there is space for them, so that their movement is checked against the side they are on
+	isRightTeam, ok := s.findOpenTeam(&game.RegisteredInstance)
+	if !ok {
+		return nil, requestErrorf(messages.ErrCodeGameFull, "game %s is full", gameID)
+	}
+	player.IsSpectator = false
+	player.PlayerAction.Pos.X = computeRandomPosX(isRightTeam)
+	player.PlayerAction.FaceRight = player.PlayerAction.Pos.X < 0
+	s.sendws(in.sess, messages.ForcePlayerMessage{
+		Action:         player.PlayerAction,
+		ServerPlayerID: player.GUID,
+	})
+
+	// send back existing players and the current score
+	s.sendGamePlayerIncludes(in.sess, &game.RegisteredInstance)
+	s.sendCurrentScore(in.sess, game)
+
+	// store the new player, who is no longer watching if they were before
+	game.Spectators.Delete(serverPlayerID)
 	game.Players.LoadOrStore(serverPlayerID, true)
+	game.RecordJoin(serverPlayerID)
 	game.UpdateTime()
 
 	// broadcast their inclusion into the game
@@ -250,13 +327,16 @@ func (s *ServerData) handleaddplayergame(conn *websocket.Conn, msgBody []byte) (
 	s.assignHostIfNone(&game.RegisteredInstance, player)
 
 	// respond by echoing the message
-	return structures.ToWrappedJSON(rq)
+	return rq, nil
 }
 
 // check if a given room code corresponds to a lobby that exists
-func (s *ServerData) handlechecklobby(msgBody []byte) ([]byte, error) {
-	var rq messages.CheckLobbyMessage
-	structures.FromWrappedJSON(&rq, msgBody)
+func (s *ServerData) handlechecklobby(in *inbound, rq messages.CheckLobbyMessage) (any, error) {
+
+	// refuse connections that have been guessing room codes
+	if err := checkLobbyAttempts(in.sess); err != nil {
+		return nil, err
+	}
 
 	// decode the message body
 	roomCode := rq.RoomCode
@@ -267,107 +347,149 @@ func (s *ServerData) handlechecklobby(msgBody []byte) ([]byte, error) {
 		response.Exists = false
 	} else {
 
-		// search for the lobby and figure out whether it exists
-		_, err := s.FindLobby(roomCode)
+		// search for the lobby and figure out whether it exists, and whether it is private; nothing about who is in it is given away
+		lobby, err := s.FindLobby(roomCode)
 		response.Exists = err == nil
+		if response.Exists {
+			response.RequiresPassword = lobby.RequiresPassword()
+		}
+	}
+	if !response.Exists {
+		failLobbyAttempt(in.sess)
 	}
-	return structures.ToWrappedJSON(response)
+	return response, nil
+}
+
+// process a request for a page of the public lobbies
+func (s *ServerData) handlelistlobbies(in *inbound, rq messages.ListLobbiesMessage) (any, error) {
+	return s.ListPublicLobbies(rq), nil
 }
 
 // process a request from a player to switch sides
-func (s *ServerData) handleswitch(msgBody []byte) ([]byte, error) {
-	var rq messages.SwitchSideMessage
-	structures.FromWrappedJSON(&rq, msgBody)
-	pguid := rq.ServerPlayerID
-	roomCode := rq.RoomCode
+func (s *ServerData) handleswitch(in *inbound, rq messages.SwitchSideMessage) (any, error) {
 
-	// find lobby
-	lobby, err := s.FindLobby(roomCode)
+	// find the player on this connection, and the lobby they are switching in
+	player, err := s.FindSessionPlayer(in.sess, rq.ServerPlayerID)
 	if err != nil {
-		return nil, fmt.Errorf("unable to find lobby to switch player in")
+		return nil, err
 	}
-
-	// find player
-	player, err := s.FindPlayer(pguid)
+	lobby, err := s.FindLobby(rq.RoomCode)
 	if err != nil {
-		return nil, fmt.Errorf("unable to find player in player map during switch request")
+		return nil, requestErrorf(messages.ErrCodeRoomNotFound, "unable to find lobby to switch player in")
 	}
 
-	// check that they match
-	if player.RoomCode == lobby.RoomCode {
-
-		// process the switch by pushing a forced update and broadcasting the new position
-		player.Pos.X *= -1
-		player.FaceRight = !player.FaceRight
+	// check that they are on the court of the lobby
+	isPlayer, isSpectator := instanceRole(&lobby.RegisteredInstance, player.GUID)
+	if isSpectator || player.IsSpectator {
+		return nil, requestErrorf(messages.ErrCodeSpectator, "spectator %s cannot switch sides", player.GUID)
+	}
+	if !isPlayer || player.RoomCode != lobby.RoomCode {
+		return nil, requestErrorf(messages.ErrCodeNotInInstance, "player id %s not found in lobby %s during switch request", player.GUID, rq.RoomCode)
+	}
 
-	} else {
-		return nil, fmt.Errorf("player id %s not found in lobby %s during switch request", pguid, roomCode)
+	// sides can't be switched during a match, since that would cross the net without a movement check, nor onto a side that is full
+	if s.isLobbyInMatch(lobby) {
+		return nil, requestErrorf(messages.ErrCodeMatchInProgress, "lobby %s is playing a match, so player %s cannot switch sides", rq.RoomCode, player.GUID)
 	}
+	lCount, rCount := s.countTeamPlayers(&lobby.RegisteredInstance)
+	otherSideCount := rCount
+	if player.Pos.X > 0 {
+		otherSideCount = lCount
+	}
+	if otherSideCount >= defs.MaxTeamPlayers {
+		return nil, requestErrorf(messages.ErrCodeLobbyFull, "the other side of lobby %s is full, so player %s cannot switch to it", rq.RoomCode, player.GUID)
+	}
+
+	// process the switch by pushing a forced update and broadcasting the new position
+	player.Pos.X *= -1
+	player.FaceRight = !player.FaceRight
 
 	// broadcast an update with the player's new position
-	msg, err := structures.ToWrappedJSON(messages.PlayerActionMessage{
+	s.broadcastws(messages.PlayerActionMessage{
 		PlayerServerID: player.GUID,
 		Action:         player.PlayerAction,
 		RoomCode:       lobby.RoomCode,
-	})
-	if err != nil {
-		return nil, err
-	} else {
-		s.broadcastws(msg, &lobby.RegisteredInstance)
-	}
+	}, &lobby.RegisteredInstance)
 
 	// send a forced update back to the client to switch the user
-	return structures.ToWrappedJSON(messages.ForcePlayerMessage{
+	return messages.ForcePlayerMessage{
 		ServerPlayerID: player.GUID,
 		Action:         player.PlayerAction,
-	})
+	}, nil
 }
 
 // process a player add to lobby request
-func (s *ServerData) handleaddplayerlobby(conn *websocket.Conn, msgBody []byte) ([]byte, error) {
-	var rq messages.AddPlayerLobbyMessage
-	structures.FromWrappedJSON(&rq, msgBody)
+func (s *ServerData) handleaddplayerlobby(in *inbound, rq messages.AddPlayerLobbyMessage) (any, error) {
 
 	// decode the message body
 	serverPlayerID := rq.ServerPlayerID
 	roomCode := rq.RoomCode
 
-	// find the player's ID on the player map
-	player, pErr := s.FindPlayer(serverPlayerID)
-	if pErr != nil {
-		return nil, fmt.Errorf("could not find player id in registry: %s", serverPlayerID)
+	// find the player on this connection; players can only add themselves
+	player, err := s.FindSessionPlayer(in.sess, serverPlayerID)
+	if err != nil {
+		return nil, err
 	}
-	player.RoomCode = roomCode
-	player.UpdateTime()
 
-	// find the lobby's ID on the lobby map
+	// find the lobby's ID on the lobby map, unless the connection has been guessing room codes
+	if err := checkLobbyAttempts(in.sess); err != nil {
+		return nil, err
+	}
 	lobby, lErr := s.FindLobby(roomCode)
 	if lErr != nil {
-		return nil, fmt.Errorf("could not find lobby with room code: %s", roomCode)
+		failLobbyAttempt(in.sess)
+		return nil, requestErrorf(messages.ErrCodeRoomNotFound, "could not find lobby with room code: %s", roomCode)
+	}
+
+	// keep out anyone the host has banned, newcomers while the lobby is locked, and those without the password or an invite to a private lobby
+	if err := checkLobbyAdmission(lobby, player, in.sess, rq.Password, rq.InviteToken); err != nil {
+		return nil, err
+	}
+	rq.Password, rq.InviteToken = "", ""
+	player.RoomCode = roomCode
+	player.UpdateTime()
+
+	// spectators only need to be sent the current state of the lobby
+	if rq.Spectate {
+		player.IsSpectator = true
+		s.sendCurrentBackdrop(in.sess, lobby)
+		s.sendGamePlayerIncludes(in.sess, &lobby.RegisteredInstance)
+		s.sendChatHistory(in.sess, player, &lobby.RegisteredInstance, "", lobby.RoomCode)
+		lobby.Players.Delete(serverPlayerID)
+		lobby.Spectators.LoadOrStore(serverPlayerID, true)
+		lobby.RecordJoin(serverPlayerID)
+		lobby.UpdateTime()
+
+		// a host who moves off the court to watch hands the host role over to one of the players
+		s.assignHostIfLeave(&lobby.RegisteredInstance, serverPlayerID)
+		return rq, nil
 	}
 
-	// autoassign them to a team and a position on the court
-	isRightTeam := s.computeNewPlayerTeam(lobby)
+	// autoassign them to a team and a position on the court, if there is space for them
+	isRightTeam, ok := s.findOpenTeam(&lobby.RegisteredInstance)
+	if !ok {
+		return nil, requestErrorf(messages.ErrCodeLobbyFull, "lobby %s is full", roomCode)
+	}
+	player.IsSpectator = false
 	player.PlayerAction.Pos.X = computeRandomPosX(isRightTeam)
 	player.PlayerAction.FaceRight = player.PlayerAction.Pos.X < 0
-	msgForcePosition, err := structures.ToWrappedJSON(messages.ForcePlayerMessage{
+	s.sendws(in.sess, messages.ForcePlayerMessage{
 		Action:         player.PlayerAction,
 		ServerPlayerID: player.GUID,
 	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to send player spawn loation: %s", err)
-	}
-	s.sendws(conn, msgForcePosition)
 
 	// send the background image resource name to the client
-	s.sendCurrentBackdrop(conn, lobby)
+	s.sendCurrentBackdrop(in.sess, lobby)
 
-	// store the player to the lobby
+	// store the player to the lobby, who is no longer watching if they were before
+	lobby.Spectators.Delete(serverPlayerID)
 	lobby.Players.LoadOrStore(serverPlayerID, true)
+	lobby.RecordJoin(serverPlayerID)
 	lobby.UpdateTime()
 
-	// send back a list of existing players in the lobby
-	s.sendGamePlayerIncludes(conn, &lobby.RegisteredInstance)
+	// send back a list of existing players in the lobby, and what they have been saying
+	s.sendGamePlayerIncludes(in.sess, &lobby.RegisteredInstance)
+	s.sendChatHistory(in.sess, player, &lobby.RegisteredInstance, "", lobby.RoomCode)
 
 	// broadcast their inclusion into the game
 	s.broadcastPlayerJoined(&lobby.RegisteredInstance, player)
@@ -375,132 +497,447 @@ func (s *ServerData) handleaddplayerlobby(conn *websocket.Conn, msgBody []byte)
 	// assign them as host if there is none
 	s.assignHostIfNone(&lobby.RegisteredInstance, player)
 
-	return structures.ToWrappedJSON(rq)
+	// send everyone the updated ready roster, since a new player may hold up the match
+	s.evaluateReadyCheck(lobby)
+
+	return rq, nil
 }
 
 // handle a request to remove a player from the lobby
-func (s *ServerData) handleleavelobby(msgBody []byte) ([]byte, error) {
-	var rq messages.LeaveLobbyMessage
-	structures.FromWrappedJSON(&rq, msgBody)
-	roomCode := rq.RoomCode
-	pid := rq.PlayerServerID
-	s.removePlayerLobby(pid, roomCode)
-	return msgBody, nil
+// * players can only remove themselves; anyone else has to be kicked by the host
+func (s *ServerData) handleleavelobby(in *inbound, rq messages.LeaveLobbyMessage) (any, error) {
+	player, err := s.FindSessionPlayer(in.sess, rq.PlayerServerID)
+	if err != nil {
+		return nil, err
+	}
+	if player.RoomCode != rq.RoomCode {
+		return nil, requestErrorf(messages.ErrCodeNotInInstance, "player id %s not found in lobby %s during leave request", player.GUID, rq.RoomCode)
+	}
+	s.removePlayerLobby(player.GUID, rq.RoomCode, "")
+	return rq, nil
 }
 
 // handle a request to remove a player from the game
-func (s *ServerData) handleleavegame(msgBody []byte) ([]byte, error) {
-	var rq messages.LeaveGameMessage
-	structures.FromWrappedJSON(&rq, msgBody)
-	gameID := rq.GameID
-	pid := rq.PlayerServerID
-	s.removePlayerGame(pid, gameID)
-	return msgBody, nil
+// * players can only remove themselves
+func (s *ServerData) handleleavegame(in *inbound, rq messages.LeaveGameMessage) (any, error) {
+	player, err := s.FindSessionPlayer(in.sess, rq.PlayerServerID)
+	if err != nil {
+		return nil, err
+	}
+	if player.GameID != rq.GameID {
+		return nil, requestErrorf(messages.ErrCodeNotInInstance, "player id %s not found in game %s during leave request", player.GUID, rq.GameID)
+	}
+	s.removePlayerGame(player.GUID, rq.GameID)
+	return rq, nil
 }
 
 // handle a request to change the backdrop in a lobby
-func (s *ServerData) handlesetbackdrop(msgBody []byte) ([]byte, error) {
-	var rq messages.SetBackdropMessage
-	structures.FromWrappedJSON(&rq, msgBody)
+func (s *ServerData) handlesetbackdrop(in *inbound, rq messages.SetBackdropMessage) (any, error) {
 	lobby, err := s.FindLobby(rq.RoomCode)
 	if err != nil {
-		return nil, fmt.Errorf("could not find lobby with room code in registry: %s", rq.RoomCode)
+		return nil, requestErrorf(messages.ErrCodeRoomNotFound, "could not find lobby with room code in registry: %s", rq.RoomCode)
 	}
 	lobby.Backdrop = rq.ResourceName
-	s.broadcastws(msgBody, &lobby.RegisteredInstance)
+	s.broadcastws(rq, &lobby.RegisteredInstance)
 	return nil, nil
 }
 
-// process a player action received from the client
-func (s *ServerData) handleplayeraction(msgBody []byte) ([]byte, error) {
+// handle a request from a player in a lobby to mark themselves as ready or not ready to start a match
+func (s *ServerData) handleready(in *inbound, rq messages.ReadyMessage) (any, error) {
 
-	// deserialize the message
-	var amsg messages.PlayerActionMessage
-	structures.FromWrappedJSON(&amsg, msgBody)
-	gameID := amsg.GameID
-	playerID := amsg.PlayerServerID
-	roomCode := amsg.RoomCode
+	// find the player on this connection and check that they are in the lobby
+	player, err := s.FindSessionPlayer(in.sess, rq.ServerPlayerID)
+	if err != nil {
+		return nil, err
+	}
+	lobby, err := s.FindLobby(rq.RoomCode)
+	if err != nil {
+		return nil, requestErrorf(messages.ErrCodeRoomNotFound, "could not find lobby with room code in registry: %s", rq.RoomCode)
+	}
+	if _, ok := lobby.Players.Load(player.GUID); !ok {
+		return nil, requestErrorf(messages.ErrCodeNotInInstance, "player id %s not found in lobby %s during ready request", player.GUID, rq.RoomCode)
+	}
+	if s.isLobbyInMatch(lobby) {
+		return nil, requestErrorf(messages.ErrCodeMatchInProgress, "lobby %s is already playing a match", rq.RoomCode)
+	}
 
-	// update the player's action stored on the server
-	if len(playerID) > 0 {
+	// update their status and check whether the match can start
+	if rq.IsReady {
+		lobby.Ready.Store(player.GUID, true)
+	} else {
+		lobby.Ready.Delete(player.GUID)
+	}
+	lobby.UpdateTime()
+	s.evaluateReadyCheck(lobby)
+	return nil, nil
+}
 
-		player, pErr := s.FindPlayer(playerID)
-		if pErr != nil {
-			return nil, fmt.Errorf("could not find player id in registry: %s", playerID)
-		}
-		player.UpdatePlayerState(&amsg.Action)
-		player.UpdateTime()
+// handle a request from the host of a lobby to configure the next match
+func (s *ServerData) handlematchsettings(in *inbound, rq messages.MatchSettingsMessage) (any, error) {
+
+	// find the lobby and check that the request came from its host
+	host, err := s.FindSessionPlayer(in.sess, rq.ServerPlayerID)
+	if err != nil {
+		return nil, err
+	}
+	lobby, err := s.FindLobby(rq.RoomCode)
+	if err != nil {
+		return nil, requestErrorf(messages.ErrCodeRoomNotFound, "could not find lobby with room code in registry: %s", rq.RoomCode)
+	}
+	if host.GUID != lobby.HostID {
+		return nil, requestErrorf(messages.ErrCodeNotHost, "player %s is not the host of lobby %s and cannot change the match settings", host.GUID, rq.RoomCode)
 	}
 
-	// find the game that it applies to
-	if len(gameID) > 0 {
+	// store the settings and let everyone know about them
+	lobby.ReadyQuorum = max(rq.ReadyQuorum, 0)
+	lobby.Rules = rq.Rules.WithDefaults()
+	lobby.UpdateTime()
+	s.broadcastws(messages.MatchSettingsMessage{
+		ServerPlayerID: host.GUID,
+		RoomCode:       lobby.RoomCode,
+		ReadyQuorum:    lobby.ReadyQuorum,
+		Rules:          lobby.Rules,
+	}, &lobby.RegisteredInstance)
 
-		game, err := s.FindGame(gameID)
-		if err != nil {
-			return nil, fmt.Errorf("could not find game id in registry: %s", gameID)
+	// the new quorum may change whether the match can start
+	s.evaluateReadyCheck(lobby)
+	return nil, nil
+}
+
+// handle a request from the host of a game to bring everyone back to the lobby it was started from
+func (s *ServerData) handlereturnlobby(in *inbound, rq messages.ReturnLobbyMessage) (any, error) {
+
+	// find the game and check that the request came from its host
+	host, err := s.FindSessionPlayer(in.sess, rq.ServerPlayerID)
+	if err != nil {
+		return nil, err
+	}
+	game, err := s.FindGame(rq.GameID)
+	if err != nil {
+		return nil, requestErrorf(messages.ErrCodeGameNotFound, "could not find game id in registry: %s", rq.GameID)
+	}
+	if host.GUID != game.HostID {
+		return nil, requestErrorf(messages.ErrCodeNotHost, "player %s is not the host of game %s and cannot end the match", host.GUID, rq.GameID)
+	}
+
+	// find the lobby that the game was started from
+	lobby, err := s.FindLobby(game.RoomCode)
+	if err != nil {
+		return nil, requestErrorf(messages.ErrCodeRoomNotFound, "could not find lobby with room code %s to return game %s to", game.RoomCode, rq.GameID)
+	}
+
+	// move everyone back into the lobby, and notify them of it
+	s.endLobbyMatch(lobby, game)
+	return nil, nil
+}
+
+// handle a request from the host to promote a spectator to a player
+func (s *ServerData) handlepromotespectator(in *inbound, rq messages.PromoteSpectatorMessage) (any, error) {
+
+	// find the instance and check that the request came from its host
+	host, err := s.FindSessionPlayer(in.sess, rq.ServerPlayerID)
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.FindInstance(rq.GameID, rq.RoomCode)
+	if err != nil {
+		return nil, err
+	}
+	if host.GUID != r.HostID {
+		return nil, requestErrorf(messages.ErrCodeNotHost, "player %s is not the host and cannot promote spectators", host.GUID)
+	}
+
+	// find the spectator
+	if _, ok := r.Spectators.Load(rq.TargetPlayerID); !ok {
+		return nil, requestErrorf(messages.ErrCodeNotInInstance, "player %s is not spectating in instance %s", rq.TargetPlayerID, r.GUID)
+	}
+	player, err := s.FindPlayer(rq.TargetPlayerID)
+	if err != nil {
+		return nil, requestErrorf(messages.ErrCodePlayerNotFound, "could not find player id in registry: %s", rq.TargetPlayerID)
+	}
+
+	// put them on a team with an open slot
+	isRightTeam, ok := s.findOpenTeam(r)
+	if !ok {
+		code := messages.ErrCodeLobbyFull
+		if len(rq.GameID) > 0 {
+			code = messages.ErrCodeGameFull
 		}
-		game.UpdateTime()
+		return nil, requestErrorf(code, "no open slot to promote spectator %s into", rq.TargetPlayerID)
+	}
+	player.IsSpectator = false
+	player.PlayerAction.Pos.X = computeRandomPosX(isRightTeam)
+	player.PlayerAction.FaceRight = player.PlayerAction.Pos.X < 0
+	player.UpdateTime()
+	r.Spectators.Delete(rq.TargetPlayerID)
+	r.Players.LoadOrStore(rq.TargetPlayerID, true)
+	r.RecordJoin(rq.TargetPlayerID)
+	r.UpdateTime()
 
-		// just broadcast the action to all clients in the game
-		s.broadcastws(msgBody, &game.RegisteredInstance)
-		return msgBody, nil
+	// spawn them on the court and let everyone know
+	s.sendToPlayer(player, messages.ForcePlayerMessage{
+		Action:         player.PlayerAction,
+		ServerPlayerID: player.GUID,
+	})
+	s.broadcastPlayerJoined(r, player)
+	s.broadcastws(rq, r)
+
+	// a new player in a lobby changes the ready roster
+	if lobby, err := s.FindLobby(rq.RoomCode); err == nil && &lobby.RegisteredInstance == r {
+		s.evaluateReadyCheck(lobby)
 	}
+	return nil, nil
+}
 
-	// or find the lobby that it applies to
-	if len(roomCode) > 0 {
+// handle a request from the host to set or remove the password of the lobby
+func (s *ServerData) handlesetlobbypassword(in *inbound, rq messages.SetLobbyPasswordMessage) (any, error) {
 
-		lobby, err := s.FindLobby(roomCode)
-		if err != nil {
-			return nil, fmt.Errorf("could not find lobby with room code in registry: %s", roomCode)
+	// find the lobby and check that the request came from its host
+	host, err := s.FindSessionPlayer(in.sess, rq.ServerPlayerID)
+	if err != nil {
+		return nil, err
+	}
+	lobby, err := s.FindLobby(rq.RoomCode)
+	if err != nil {
+		return nil, requestErrorf(messages.ErrCodeRoomNotFound, "could not find lobby with room code: %s", rq.RoomCode)
+	}
+	if host.GUID != lobby.HostID {
+		return nil, requestErrorf(messages.ErrCodeNotHost, "player %s is not the host and cannot set the lobby's password", host.GUID)
+	}
+	if n := utf8.RuneCountInString(rq.Password); n > defs.MaxLobbyPasswordLength {
+		return nil, requestErrorf(messages.ErrCodeBadRequest, "password is %d characters long, over the limit of %d", n, defs.MaxLobbyPasswordLength)
+	}
+
+	// apply it, and confirm it to the host without echoing the password
+	lobby.SetPassword(rq.Password)
+	lobby.UpdateTime()
+	return messages.SetLobbyPasswordMessage{
+		ServerPlayerID:   host.GUID,
+		RoomCode:         lobby.RoomCode,
+		RequiresPassword: lobby.RequiresPassword(),
+	}, nil
+}
+
+// handle a request from the host for a one-time invite to the lobby
+func (s *ServerData) handlecreateinvite(in *inbound, rq messages.CreateInviteMessage) (any, error) {
+
+	// find the lobby and check that the request came from its host
+	host, err := s.FindSessionPlayer(in.sess, rq.ServerPlayerID)
+	if err != nil {
+		return nil, err
+	}
+	lobby, err := s.FindLobby(rq.RoomCode)
+	if err != nil {
+		return nil, requestErrorf(messages.ErrCodeRoomNotFound, "could not find lobby with room code: %s", rq.RoomCode)
+	}
+	if host.GUID != lobby.HostID {
+		return nil, requestErrorf(messages.ErrCodeNotHost, "player %s is not the host and cannot create invites", host.GUID)
+	}
+
+	// issue the invite to the host, who passes it on
+	token, ok := lobby.CreateInvite()
+	if !ok {
+		return nil, requestErrorf(messages.ErrCodeRateLimited, "lobby %s has %d unused invites, which is the most allowed", lobby.RoomCode, defs.MaxPendingInvites)
+	}
+	rq.InviteToken = token
+	return rq, nil
+}
+
+// handle a request from the host to hand off host status to another player
+func (s *ServerData) handletransferhost(in *inbound, rq messages.TransferHostMessage) (any, error) {
+
+	// find the instance and check that the request came from its host
+	host, err := s.FindSessionPlayer(in.sess, rq.ServerPlayerID)
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.FindInstance(rq.GameID, rq.RoomCode)
+	if err != nil {
+		return nil, err
+	}
+	if host.GUID != r.HostID {
+		return nil, requestErrorf(messages.ErrCodeNotHost, "player %s is not the host and cannot transfer host status", host.GUID)
+	}
+
+	// only players on the court may become host, the same as in an election
+	if isPlayer, isSpectator := instanceRole(r, rq.TargetPlayerID); isSpectator {
+		return nil, requestErrorf(messages.ErrCodeSpectator, "spectator %s cannot become the host", rq.TargetPlayerID)
+	} else if !isPlayer {
+		return nil, requestErrorf(messages.ErrCodeNotInInstance, "player %s is not in instance %s", rq.TargetPlayerID, r.GUID)
+	}
+
+	// hand it off and let everyone know
+	r.HostID = rq.TargetPlayerID
+	r.UpdateTime()
+	s.broadcastSyncHostMessage(r, r.HostID)
+	return nil, nil
+}
+
+// handle a request from the host to remove a player from the lobby, and optionally ban them from rejoining it
+func (s *ServerData) handlekickplayer(in *inbound, rq messages.KickPlayerMessage) (any, error) {
+
+	// find the lobby and check that the request came from its host
+	host, err := s.FindSessionPlayer(in.sess, rq.ServerPlayerID)
+	if err != nil {
+		return nil, err
+	}
+	lobby, err := s.FindLobby(rq.RoomCode)
+	if err != nil {
+		return nil, requestErrorf(messages.ErrCodeRoomNotFound, "could not find lobby with room code: %s", rq.RoomCode)
+	}
+	if host.GUID != lobby.HostID {
+		return nil, requestErrorf(messages.ErrCodeNotHost, "player %s is not the host and cannot kick players", host.GUID)
+	}
+
+	// find the player to kick
+	if rq.TargetPlayerID == host.GUID {
+		return nil, requestErrorf(messages.ErrCodeBadRequest, "hosts cannot kick themselves")
+	}
+	if isPlayer, isSpectator := instanceRole(&lobby.RegisteredInstance, rq.TargetPlayerID); !isPlayer && !isSpectator {
+		return nil, requestErrorf(messages.ErrCodeNotInInstance, "player %s is not in lobby %s", rq.TargetPlayerID, lobby.RoomCode)
+	}
+	target, err := s.FindPlayer(rq.TargetPlayerID)
+	if err != nil {
+		return nil, requestErrorf(messages.ErrCodePlayerNotFound, "could not find player id in registry: %s", rq.TargetPlayerID)
+	}
+
+	// check the reason shown to the player, the same way as a chat message
+	reason := strings.TrimSpace(rq.Reason)
+	if n := utf8.RuneCountInString(reason); n > defs.MaxChatLength {
+		return nil, requestErrorf(messages.ErrCodeBadRequest, "kick reason is %d characters long, over the limit of %d", n, defs.MaxChatLength)
+	}
+	if s.WordFilter != nil {
+		reason = filter.Mask(s.WordFilter, reason)
+	}
+	if len(reason) == 0 {
+		reason = "Kicked by the host"
+		if rq.Ban {
+			reason = "Banned by the host"
 		}
-		lobby.UpdateTime()
+	}
 
-		// just broadcast the action to all clients in the lobby
-		s.broadcastws(msgBody, &lobby.RegisteredInstance)
-		return msgBody, nil
+	// ban them before removing them, so that they can't slip back in
+	if rq.Ban {
+		lobby.Ban(target.GUID, target.GetSessionID())
 	}
+	log.Printf("Host %s removed player %s from lobby %s (ban: %t)", host.GUID, target.GUID, lobby.RoomCode, rq.Ban)
+	s.removePlayerLobby(target.GUID, lobby.RoomCode, reason)
+	return nil, nil
+}
+
+// handle a request from the host to lock or unlock the lobby against new joins
+func (s *ServerData) handlelocklobby(in *inbound, rq messages.LockLobbyMessage) (any, error) {
 
-	// somehow if the message was empty on both gameID and roomcode..? send this error
-	return msgBody, fmt.Errorf("could not find matching instance for player action (nil gameID and roomCode received?)")
+	// find the lobby and check that the request came from its host
+	host, err := s.FindSessionPlayer(in.sess, rq.ServerPlayerID)
+	if err != nil {
+		return nil, err
+	}
+	lobby, err := s.FindLobby(rq.RoomCode)
+	if err != nil {
+		return nil, requestErrorf(messages.ErrCodeRoomNotFound, "could not find lobby with room code: %s", rq.RoomCode)
+	}
+	if host.GUID != lobby.HostID {
+		return nil, requestErrorf(messages.ErrCodeNotHost, "player %s is not the host and cannot lock the lobby", host.GUID)
+	}
+
+	// apply it and let everyone know
+	lobby.SetLocked(rq.Locked)
+	lobby.UpdateTime()
+	s.broadcastws(rq, &lobby.RegisteredInstance)
+	return nil, nil
+}
+
+// process a player action received from the client
+func (s *ServerData) handleplayeraction(in *inbound, amsg messages.PlayerActionMessage) (any, error) {
+
+	// find the player on this connection, and the game or lobby that the action takes place in
+	player, err := s.FindSessionPlayer(in.sess, amsg.PlayerServerID)
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.FindInstance(amsg.GameID, amsg.RoomCode)
+	if err != nil {
+		return nil, err
+	}
+
+	// only players on the court may move
+	isPlayer, isSpectator := instanceRole(r, player.GUID)
+	if isSpectator || player.IsSpectator {
+		return nil, requestErrorf(messages.ErrCodeSpectator, "ignoring player action from spectator %s", player.GUID)
+	}
+	if !isPlayer {
+		return nil, requestErrorf(messages.ErrCodeNotInInstance, "player id %s not found in instance %s during player action", player.GUID, r.GUID)
+	}
+
+	// check the action against the player's stats, and correct their client if it is too far off to accept
+	action, ok := s.validatePlayerMovement(player, amsg.Action)
+	if !ok {
+		return messages.ForcePlayerMessage{
+			Action:         player.PlayerAction,
+			ServerPlayerID: player.GUID,
+		}, nil
+	}
+	amsg.Action = action
+	player.UpdatePlayerState(&amsg.Action)
+	player.UpdateTime()
+	r.UpdateTime()
+
+	// just broadcast the action to all clients in the instance, unless it goes out with the next snapshot
+	if r.SnapshotHz > 0 {
+		return nil, nil
+	}
+	s.broadcastwsTransient(amsg, r)
+	return amsg, nil
+}
+
+// handle a client's acknowledgement of a snapshot, so that the next ones are sent as changes against it
+func (s *ServerData) handlesnapshotack(in *inbound, rq messages.SnapshotAckMessage) (any, error) {
+	r, err := s.FindInstance(rq.GameID, rq.RoomCode)
+	if err != nil {
+		return nil, err
+	}
+	if !in.sess.ackSnapshot(r.GUID, rq.Tick) {
+		log.Printf("[%s] Ignoring acknowledgement of snapshot %d of instance %s, which is no longer held", in.sess, rq.Tick, r.GUID)
+	}
+	return nil, nil
 }
 
 // process a ball event received from the client
-func (s *ServerData) handleballevent(msgBody []byte) ([]byte, error) {
+func (s *ServerData) handleballevent(in *inbound, bsm messages.BallStateMessage) (any, error) {
 
-	// deserialize the message
-	var bsm messages.BallStateMessage
-	structures.FromWrappedJSON(&bsm, msgBody)
 	clientBall := bsm.Ball
 	gameID := bsm.GameID
-	structures.FromWrappedJSON(&clientBall, msgBody)
 
 	// find the game that it applies to
 	game, err := s.FindGame(gameID)
 	if err != nil {
-		return nil, fmt.Errorf("could not find game id in registry: %s", gameID)
+		return nil, requestErrorf(messages.ErrCodeGameNotFound, "could not find game id in registry: %s", gameID)
 	}
 	game.UpdateTime()
 
 	// if for whatever reason the client's copy of the ball is out of date (e.g. someone else has registered a hit before them or the ball has already died), do not process the request and return a harmless error to the client
-	denyBallUpdate := func(reason string) ([]byte, error) {
-		err := fmt.Errorf("ball touch request denied, reason: %s", reason)
+	denyBallUpdate := func(reason string) (any, error) {
+		err := requestErrorf(messages.ErrCodeBallDenied, "ball touch request denied, reason: %s", reason)
 		log.Printf("Ball touch denied from: %s; reason: %s", clientBall.TouchedBy, reason)
 		return nil, err
 	}
 
-	// accept the ball update and broadcast it
-	acceptBallUpdate := func(b *states.BallState, gameID string) ([]byte, error) {
-		ballMsg := messages.BallStateMessage{
-			Ball:   *b,
-			GameID: gameID,
-		}
-		sendMsg, err := structures.ToWrappedJSON(ballMsg)
-		if err == nil {
-			s.broadcastws(sendMsg, &game.RegisteredInstance)
-		} else {
-			log.Printf("Error broadcasting accepted ball status: %s", err)
-		}
-		return nil, err
+	// accept the ball update and broadcast it; from here on the server simulates its trajectory
+	acceptBallUpdate := func(b *states.BallState) (any, error) {
+		s.broadcastBallState(game, b)
+		return nil, nil
+	}
+
+	// the ball can only be touched by a player of the game on the connection that sent the touch; spectators cannot take part in play
+	toucher, err := s.FindSessionPlayer(in.sess, clientBall.TouchedBy)
+	if err != nil {
+		return denyBallUpdate(fmt.Sprintf("Toucher %s is not a player on this connection", clientBall.TouchedBy))
+	}
+	if isPlayer, isSpectator := instanceRole(&game.RegisteredInstance, toucher.GUID); isSpectator {
+		return denyBallUpdate("Spectators cannot touch the ball")
+	} else if !isPlayer {
+		return denyBallUpdate(fmt.Sprintf("Toucher %s is not a player in the game", toucher.GUID))
 	}
 
 	// grab a local copy of the game ball
@@ -512,14 +949,31 @@ func (s *ServerData) handleballevent(msgBody []byte) ([]byte, error) {
 		// handle new ball registry
 		clientBall.GenerateGUID()
 
-		// register it to the game
-		if cachedGameBall == nil {
-			game.UpdateBall(&clientBall)
-			log.Printf("Logged new game ball on server : %s", clientBall.GUID)
-			return acceptBallUpdate(&clientBall, game.GUID)
-		} else {
+		// a new ball can only be served while no other ball is live, and only by the serving team
+		score := game.Match.Score()
+		if score.IsOver {
+			return denyBallUpdate("The match is already over")
+		} else if cachedGameBall != nil {
 			return denyBallUpdate("A live game ball already exists")
 		}
+		team := states.TeamAtPosX(toucher.Pos.X)
+		if team != score.ServingTeam {
+			return denyBallUpdate(fmt.Sprintf("Team %d is not serving", team))
+		}
+
+		// check that the serving player was within reach of the ball they report serving, then start the ball from the server's own copy of their position; only the strike is taken from the client
+		if reason := checkPlayerReach(toucher, clientBall.Pos, in.receivedAt()); len(reason) > 0 {
+			return denyBallUpdate(reason)
+		}
+		clientBall.Pos = servePosition(toucher)
+		clientBall.LiveState = states.BallLiveStateAlive
+
+		// register it to the game, with the serve as the serving team's first touch
+		game.Touches.Reset()
+		s.registerTouch(game, toucher)
+		game.UpdateBall(clientBall.Clone())
+		log.Printf("Logged new game ball on server : %s", clientBall.GUID)
+		return acceptBallUpdate(&clientBall)
 
 	} else {
 
@@ -533,26 +987,172 @@ func (s *ServerData) handleballevent(msgBody []byte) ([]byte, error) {
 		isAlive := clientBall.IsAlive()
 		if isAlive {
 
-			// if the ball is still alive, it means the player touched it; check if the touch count makes sense
-			isTouchCountCorrect := clientBall.TouchCount <= 1 || (clientBall.TouchCount-cachedGameBall.TouchCount == 1)
+			// if the ball is still alive, it means the player touched it; rewind to the time that the toucher's client perceived the ball at
+			received := in.receivedAt()
+			perceived := perceivedTime(received, toucher, game.Match.Rules.MaxRewindMs)
+			rewindTo := perceived
+
+			// a touch on the same ball state as the latest accepted touch competes with it, and the touch perceived first wins regardless of which arrived first
+			baseBall := cachedGameBall
+			last := game.LastTouch()
+			competing := last.BallID == clientBall.GUID && last.PlayerID != clientBall.TouchedBy && last.TouchCount == clientBall.TouchCount && cachedGameBall.TouchedBy == last.PlayerID
+			if competing {
+				if !perceived.Before(last.PerceivedTime) {
+					return denyBallUpdate(fmt.Sprintf("Touch by %s was made first", last.PlayerID))
+				}
+				baseBall = last.BallBefore
+
+				// the server's history of the ball after the replaced touch follows that touch, so don't rewind into it
+				if !rewindTo.Before(last.AcceptedAt) {
+					rewindTo = last.AcceptedAt.Add(-time.Millisecond)
+				}
+			}
+
+			// check if the touch count makes sense
+			isTouchCountCorrect := clientBall.TouchCount <= 1 || (clientBall.TouchCount-baseBall.TouchCount == 1)
 			if !isTouchCountCorrect {
-				return denyBallUpdate(fmt.Sprintf("Touch count incorrect: %d (client) vs %d (server)", clientBall.TouchCount, cachedGameBall.TouchCount))
+				return denyBallUpdate(fmt.Sprintf("Touch count incorrect: %d (client) vs %d (server)", clientBall.TouchCount, baseBall.TouchCount))
 			}
 
-			// broadcast the updated client ball to other players
-			game.UpdateBall(&clientBall)
-			return acceptBallUpdate(&clientBall, game.GUID)
+			// check that the touch was possible at the time it was perceived
+			if reason := checkTouchReach(game, toucher, &clientBall, rewindTo, received); len(reason) > 0 {
+				return denyBallUpdate(reason)
+			}
 
-		} else {
+			// undo the touch being replaced
+			if competing {
+				log.Printf("Touch by %s was perceived %v before the touch by %s; replacing it", clientBall.TouchedBy, last.PerceivedTime.Sub(perceived), last.PlayerID)
+				game.Touches.Restore(last.TouchesBefore)
+			}
 
-			// it's possible that the game ball already died and has been set to nil
-			if cachedGameBall == nil {
-				return denyBallUpdate("Game ball already died or doesn't exist")
+			// enforce the touch rules of the match, and fault the touching team if they were broken
+			touchesBefore := game.Touches.Snapshot()
+			if team, fault := s.registerTouch(game, toucher); len(fault) > 0 {
+				s.faultBall(game, clientBall.GUID, team, fault)
+				return denyBallUpdate(fmt.Sprintf("Touch fault: %s", fault))
 			}
 
-			// if game ball was alive but client says it's dead, broadcast the dead ball and kill the ball on game side
-			game.UpdateBall(nil)
-			return acceptBallUpdate(&clientBall, game.GUID)
+			// take the client's touch as the new starting point of the ball's trajectory, unless the ball landed or died while the touch was being checked
+			if !game.TouchBall(clientBall.GUID, clientBall.Clone()) {
+				game.Touches.Restore(touchesBefore)
+				return denyBallUpdate("Ball is no longer live")
+			}
+			game.SetLastTouch(states.AcceptedTouch{
+				BallID:        clientBall.GUID,
+				PlayerID:      clientBall.TouchedBy,
+				TouchCount:    clientBall.TouchCount,
+				PerceivedTime: perceived,
+				AcceptedAt:    received,
+				BallBefore:    baseBall,
+				TouchesBefore: touchesBefore,
+			})
+
+			// broadcast it to other players
+			return acceptBallUpdate(&clientBall)
+
+		} else {
+
+			// the server decides when the ball dies, based on its own simulation of the trajectory
+			return denyBallUpdate("Ball death is decided by the server")
 		}
 	}
 }
+
+// process a chat message from a player, and pass it on to everyone in their lobby or game who may see it
+func (s *ServerData) handlechat(in *inbound, rq messages.ChatMessage) (any, error) {
+
+	// find the sender and the instance they are chatting in
+	player, err := s.FindSessionPlayer(in.sess, rq.ServerPlayerID)
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.FindInstance(rq.GameID, rq.RoomCode)
+	if err != nil {
+		return nil, err
+	}
+	isPlayer, isSpectator := instanceRole(r, player.GUID)
+	if !isPlayer && !isSpectator {
+		return nil, requestErrorf(messages.ErrCodeNotInInstance, "player %s is not in the instance they are chatting in", player.GUID)
+	}
+
+	// check the message
+	text := strings.TrimSpace(rq.Text)
+	if len(text) == 0 {
+		return nil, requestErrorf(messages.ErrCodeBadRequest, "chat message is empty")
+	}
+	if n := utf8.RuneCountInString(text); n > defs.MaxChatLength {
+		return nil, requestErrorf(messages.ErrCodeBadRequest, "chat message is %d characters long, over the limit of %d", n, defs.MaxChatLength)
+	}
+	if rq.TeamOnly && !isPlayer {
+		return nil, requestErrorf(messages.ErrCodeSpectator, "spectators have no team to chat with")
+	}
+	if !player.ChatLimiter.Allow(time.Now(), defs.ChatBurst, defs.ChatRefillSeconds*time.Second) {
+		return nil, requestErrorf(messages.ErrCodeRateLimited, "chat messages are being sent too quickly")
+	}
+	if s.WordFilter != nil {
+		text = filter.Mask(s.WordFilter, text)
+	}
+
+	// keep it in the instance's history and pass it on
+	line := states.ChatLine{
+		ServerPlayerID: player.GUID,
+		DisplayName:    player.DisplayName,
+		Text:           text,
+		TeamOnly:       rq.TeamOnly,
+		Team:           states.TeamAtPosX(player.Pos.X),
+		SentAt:         time.Now().UnixMilli(),
+	}
+	r.Chat.Add(line)
+	s.broadcastChat(r, line, rq.GameID, rq.RoomCode)
+	return nil, nil
+}
+
+// mute or unmute another player's chat for a player
+func (s *ServerData) handlemute(in *inbound, rq messages.MuteMessage) (any, error) {
+	player, err := s.FindSessionPlayer(in.sess, rq.ServerPlayerID)
+	if err != nil {
+		return nil, err
+	}
+	if rq.TargetPlayerID == player.GUID {
+		return nil, requestErrorf(messages.ErrCodeBadRequest, "players cannot mute themselves")
+	}
+	player.SetMuted(rq.TargetPlayerID, rq.Muted)
+	return rq, nil
+}
+
+// process an emote fired by a player, and pass it on to everyone in their lobby or game
+func (s *ServerData) handleemote(in *inbound, rq messages.EmoteMessage) (any, error) {
+
+	// find the player and the instance they are in; only those on the court have an avatar to emote with
+	player, err := s.FindSessionPlayer(in.sess, rq.ServerPlayerID)
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.FindInstance(rq.GameID, rq.RoomCode)
+	if err != nil {
+		return nil, err
+	}
+	if isPlayer, isSpectator := instanceRole(r, player.GUID); isSpectator {
+		return nil, requestErrorf(messages.ErrCodeSpectator, "spectators cannot fire emotes")
+	} else if !isPlayer {
+		return nil, requestErrorf(messages.ErrCodeNotInInstance, "player %s is not in the instance they are emoting in", player.GUID)
+	}
+
+	// check the emote against the catalog and the player's cooldown
+	emote, ok := s.Emotes.Lookup(rq.Code)
+	if !ok {
+		return nil, requestErrorf(messages.ErrCodeBadRequest, "unknown emote: %s", rq.Code)
+	}
+	cooldown := emote.CooldownMs
+	if cooldown <= 0 {
+		cooldown = defs.EmoteCooldownMs
+	}
+	if !player.EmoteCooldown.Try(time.Now(), time.Duration(cooldown)*time.Millisecond) {
+		return nil, requestErrorf(messages.ErrCodeRateLimited, "emotes are being fired too quickly")
+	}
+
+	// pass it on
+	rq.Text = emote.Text
+	s.broadcastws(rq, r)
+	return nil, nil
+}
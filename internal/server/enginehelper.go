@@ -1,18 +1,18 @@
 package server
 
 import (
+	"fmt"
 	"log"
 	"math"
 	"math/rand"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
 	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/messages"
 	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/states"
-	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/structures"
 	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/util"
-
-	"github.com/gorilla/websocket"
 )
 
 // This file contains the helper functions for the engine
@@ -30,52 +30,281 @@ func (s *ServerData) assignHostIfNone(r *states.RegisteredInstance, player *stat
 // for a given leaving player, check if it is the host and reassign the host as necessary
 func (s *ServerData) assignHostIfLeave(r *states.RegisteredInstance, leavingPlayerID string) {
 	if leavingPlayerID == r.HostID {
-		r.HostID = ""
-		r.Players.Range(func(pid, _ interface{}) bool {
-			if pid.(string) == leavingPlayerID {
-				return true
-			}
-			r.HostID = pid.(string)
-			return false
-		})
+		r.HostID = s.electHost(r, leavingPlayerID)
 		s.broadcastSyncHostMessage(r, r.HostID)
 	}
 }
 
+// the rules for electing a new host when the current one leaves
+type HostElection int
+
+const (
+	ElectLongestPresent HostElection = iota // the player who has been in the instance the longest
+	ElectLowestPing                         // the player with the lowest measured round trip time; players who have not been measured come after, by how long they have been present
+)
+
+// returns the host election rule with the given name ("longest" or "ping"), or false if there is none
+func HostElectionByName(name string) (HostElection, bool) {
+	switch strings.ToLower(name) {
+	case "longest":
+		return ElectLongestPresent, true
+	case "ping":
+		return ElectLowestPing, true
+	default:
+		return ElectLongestPresent, false
+	}
+}
+
+// choose the next host of an instance from its players (not spectators) according to the server's election rule; returns an empty id if there is no one to choose
+// * candidates are considered in join order, so that the outcome does not depend on the order in which the player map is ranged over
+func (s *ServerData) electHost(r *states.RegisteredInstance, leavingPlayerID string) string {
+	hostID := ""
+	bestRTT := 0.0
+	for _, pid := range r.PlayersByJoinOrder() {
+		if pid == leavingPlayerID {
+			continue
+		}
+		if s.HostElection != ElectLowestPing {
+			return pid
+		}
+		player, err := s.FindPlayer(pid)
+		if err != nil || !player.Latency.Measured() {
+			if len(hostID) == 0 {
+				hostID, bestRTT = pid, math.Inf(1)
+			}
+			continue
+		}
+		if rtt, _ := player.Latency.RTT(); len(hostID) == 0 || rtt < bestRTT {
+			hostID, bestRTT = pid, rtt
+		}
+	}
+	return hostID
+}
+
 // helper function to send one joining player's info to all connections in a registered instance
 func (s *ServerData) broadcastPlayerJoined(r *states.RegisteredInstance, player *states.PlayerState) {
+	rtt, _ := player.Latency.RTT()
 	includeMsg := messages.PlayerIncludeMessage{
 		Attributes:     player.PlayerAttributes,
 		Action:         player.PlayerAction,
 		ServerPlayerID: player.GUID,
+		RTTMs:          rtt,
 	}
-	msg, err := structures.ToWrappedJSON(includeMsg)
-	if err != nil {
-		log.Printf("Unable to wrap PlayerIncludeMessage in a json: %s", err)
-	} else {
-		s.broadcastws(msg, r)
+	s.broadcastws(includeMsg, r)
+}
+
+// store a new game on the server and start its background routines
+func (s *ServerData) registerGame(game *states.GameState) {
+	s.Games.LoadOrStore(game.GUID, game)
+
+	// start a routine that times the game out if too much time has passed since it last updated
+	checkTimeout := func(g *states.GameState) {
+		for g != nil {
+			if g.RegisteredInstance.IsTimeoutExpired() {
+				log.Printf("Deleting game %s due to timeout", g.GUID)
+				s.Games.CompareAndDelete(g.GUID, g)
+				break
+			}
+			time.Sleep(time.Minute) // sleep for some time to prevent high CPU usage and avoid tight looping
+		}
+	}
+	go checkTimeout(game)
+
+	// start the server-side simulation of the game ball
+	go s.simulateBall(game)
+
+	// start sending snapshots of the game, if it has a snapshot rate
+	go s.runSnapshots(snapshotSource{
+		r:      &game.RegisteredInstance,
+		gameID: game.GUID,
+		exists: func() bool {
+			g, err := s.FindGame(game.GUID)
+			return err == nil && g == game
+		},
+		ball: game.GetBallCopy,
+	})
+}
+
+// returns whether a lobby is currently playing a match in a game that still exists
+func (s *ServerData) isLobbyInMatch(lobby *states.LobbyState) bool {
+	if len(lobby.GameID) == 0 {
+		return false
+	}
+	_, err := s.FindGame(lobby.GameID)
+	return err == nil
+}
+
+// broadcast the ready roster of a lobby, and start or cancel the countdown to the match depending on whether enough players are ready
+func (s *ServerData) evaluateReadyCheck(lobby *states.LobbyState) {
+	if s.isLobbyInMatch(lobby) {
+		return
+	}
+
+	// list the ready players who are still in the lobby
+	readyIDs := []string{}
+	numPlayers := 0
+	lobby.Players.Range(func(pid, _ interface{}) bool {
+		numPlayers++
+		if _, ok := lobby.Ready.Load(pid); ok {
+			readyIDs = append(readyIDs, pid.(string))
+		}
+		return true
+	})
+	required := lobby.RequiredReady(numPlayers)
+
+	// broadcast the roster
+	s.broadcastws(messages.ReadyRosterMessage{
+		RoomCode:       lobby.RoomCode,
+		ReadyPlayerIDs: readyIDs,
+		RequiredReady:  required,
+		NumPlayers:     numPlayers,
+	}, &lobby.RegisteredInstance)
+
+	// start or cancel the countdown
+	if numPlayers >= defs.MinPlayersToStart && len(readyIDs) >= required {
+		if countdown, ok := lobby.StartCountdown(); ok {
+			log.Printf("Starting match countdown in lobby %s", lobby.RoomCode)
+			go s.runMatchCountdown(lobby, countdown)
+		}
+	} else if lobby.CancelCountdown() {
+		log.Printf("Cancelled match countdown in lobby %s", lobby.RoomCode)
+		s.broadcastCountdown(lobby, 0, true)
 	}
 }
 
+// count down to the start of a lobby's match, and start it unless the countdown gets cancelled
+func (s *ServerData) runMatchCountdown(lobby *states.LobbyState, countdown chan struct{}) {
+	for secondsLeft := defs.MatchCountdownSeconds; secondsLeft > 0; secondsLeft-- {
+		s.broadcastCountdown(lobby, secondsLeft, false)
+		select {
+		case <-countdown:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+	if !lobby.FinishCountdown(countdown) || !s.LobbyExists(lobby.RoomCode) {
+		return
+	}
+
+	// everyone will need to ready up again for the next match
+	lobby.Ready.Clear()
+	s.startLobbyMatch(lobby, lobby.Rules)
+}
+
+// broadcast the number of seconds left before a lobby's match starts, or that the countdown was cancelled
+func (s *ServerData) broadcastCountdown(lobby *states.LobbyState, secondsLeft int, cancelled bool) {
+	s.broadcastws(messages.CountdownMessage{
+		RoomCode:    lobby.RoomCode,
+		SecondsLeft: secondsLeft,
+		Cancelled:   cancelled,
+	}, &lobby.RegisteredInstance)
+}
+
+// move all players of a lobby into a new game and notify them of the game's id
+func (s *ServerData) startLobbyMatch(lobby *states.LobbyState, rules states.GameRules) *states.GameState {
+
+	// create the game from the lobby and register it
+	game := lobby.CreateGameInstance()
+	game.Match = states.NewMatchState(rules)
+	s.registerGame(game)
+	lobby.GameID = game.GUID
+
+	// move the players and spectators over
+	movePlayer := func(m *sync.Map) func(pid, _ interface{}) bool {
+		return func(pid, _ interface{}) bool {
+			player, err := s.FindPlayer(pid.(string))
+			if err != nil {
+				log.Printf("Could not find expected player in lobby %s while starting match, player id: %s", lobby.RoomCode, pid.(string))
+				m.Delete(pid)
+				game.ForgetJoin(pid.(string))
+			} else {
+				player.GameID = game.GUID
+				player.UpdateTime()
+			}
+			return true
+		}
+	}
+	game.Players.Range(movePlayer(&game.Players))
+	game.Spectators.Range(movePlayer(&game.Spectators))
+	log.Printf("Started match in game %s from lobby %s", game.GUID, lobby.RoomCode)
+
+	// notify everyone of the game that they have been moved to
+	s.broadcastws(messages.StartMatchMessage{
+		ServerPlayerID: lobby.HostID,
+		RoomCode:       lobby.RoomCode,
+		GameID:         game.GUID,
+		Rules:          game.Match.Rules,
+	}, &lobby.RegisteredInstance)
+	return game
+}
+
+// move all players of a game back into the lobby that it was started from, and remove the game
+func (s *ServerData) endLobbyMatch(lobby *states.LobbyState, game *states.GameState) {
+
+	// remove the game and detach its players from it
+	s.Games.CompareAndDelete(game.GUID, game)
+	if lobby.GameID == game.GUID {
+		lobby.GameID = ""
+	}
+	detachPlayer := func(pid, _ interface{}) bool {
+		player, err := s.FindPlayer(pid.(string))
+		if err == nil && player.GameID == game.GUID {
+			player.GameID = ""
+			player.UpdateTime()
+		}
+		return true
+	}
+	game.Players.Range(detachPlayer)
+	game.Spectators.Range(detachPlayer)
+	lobby.UpdateTime()
+	log.Printf("Returned players of game %s to lobby %s", game.GUID, lobby.RoomCode)
+
+	// notify everyone that they are back in the lobby
+	s.broadcastws(messages.ReturnLobbyMessage{
+		ServerPlayerID: game.HostID,
+		GameID:         game.GUID,
+		RoomCode:       lobby.RoomCode,
+	}, &lobby.RegisteredInstance)
+}
+
+// broadcast the state of a game ball to all players in the game
+func (s *ServerData) broadcastBallState(game *states.GameState, b *states.BallState) {
+	ballMsg := messages.BallStateMessage{
+		Ball:   *b,
+		GameID: game.GUID,
+	}
+	s.broadcastws(ballMsg, &game.RegisteredInstance)
+}
+
+// broadcast the current score of the match to all players in the game
+func (s *ServerData) broadcastScore(game *states.GameState) {
+	s.broadcastws(messages.ScoreMessage{
+		Score:  game.Match.Score(),
+		GameID: game.GUID,
+	}, &game.RegisteredInstance)
+}
+
 // broadcast the new host in a lobby
 func (s *ServerData) broadcastSyncHostMessage(r *states.RegisteredInstance, hostID string) {
 	msg := messages.SyncHostMessage{
 		HostID: hostID,
 	}
-	sendMsg, err := structures.ToWrappedJSON(msg)
-	if err != nil {
-		log.Printf("Unable to send new host message to lobby: %s", err)
-	} else {
-		s.broadcastws(sendMsg, r)
-	}
+	s.broadcastws(msg, r)
 }
 
 // assigns a new player to the team with fewer players, or the left if both have same; returns the team that they are on; left = false, right = true
-func (s *ServerData) computeNewPlayerTeam(l *states.LobbyState) bool {
-	lCount, rCount := s.countTeamPlayers(&l.RegisteredInstance)
+func (s *ServerData) computeNewPlayerTeam(r *states.RegisteredInstance) bool {
+	lCount, rCount := s.countTeamPlayers(r)
 	return lCount > rCount
 }
 
+// finds the team that a new player should be assigned to, and returns whether it has a free slot for them
+func (s *ServerData) findOpenTeam(r *states.RegisteredInstance) (bool, bool) {
+	lCount, rCount := s.countTeamPlayers(r)
+	isRightTeam := lCount > rCount
+	return isRightTeam, min(lCount, rCount) < defs.MaxTeamPlayers
+}
+
 // a helper to return either 1 or -1 corresponding to the sides of the court
 func computeSideMultiplier(isRightSide bool) float32 {
 	var sideSign float32
@@ -88,6 +317,11 @@ func computeSideMultiplier(isRightSide bool) float32 {
 	return sideSign
 }
 
+// return a generated name for a player whose own display name can't be shown
+func generateGuestName() string {
+	return fmt.Sprintf("Guest%04d", rand.Intn(10000))
+}
+
 // return a random x position on the court on the given side
 func computeRandomPosX(isRightSide bool) float32 {
 	source := rand.NewSource(time.Now().UnixNano())
@@ -117,36 +351,127 @@ func (s *ServerData) countTeamPlayers(r *states.RegisteredInstance) (int, int) {
 }
 
 // send the current backdrop's resource name in the lobby to a player, if null
-func (s *ServerData) sendCurrentBackdrop(conn *websocket.Conn, lobby *states.LobbyState) {
-	msgBackdrop, err := structures.ToWrappedJSON(messages.SetBackdropMessage{
+func (s *ServerData) sendCurrentBackdrop(sess *Session, lobby *states.LobbyState) {
+	s.sendws(sess, messages.SetBackdropMessage{
 		ResourceName: lobby.Backdrop,
 		RoomCode:     lobby.RoomCode,
 	})
+}
+
+// send the current score of the match in a game to a connection
+func (s *ServerData) sendCurrentScore(sess *Session, game *states.GameState) {
+	s.sendws(sess, messages.ScoreMessage{
+		Score:  game.Match.Score(),
+		GameID: game.GUID,
+	})
+}
+
+// send a message to the session of a single player
+func (s *ServerData) sendToPlayer(player *states.PlayerState, msg any) {
+	sess, err := s.FindSession(player.GetSessionID())
 	if err != nil {
-		log.Printf("failed to send backdrop resource name: %s", err)
-	} else {
-		s.sendws(conn, msgBackdrop)
+		log.Printf("client not found for player %s: %s", player.GUID, err)
+		return
 	}
+	s.sendws(sess, msg)
+}
+
+// returns whether a player is on the court or spectating in an instance
+func instanceRole(r *states.RegisteredInstance, playerID string) (bool, bool) {
+	_, isPlayer := r.Players.Load(playerID)
+	_, isSpectator := r.Spectators.Load(playerID)
+	return isPlayer, isSpectator
+}
+
+// returns whether a player may see a chat message: it must not be from someone they muted, and team-only messages must be from their own team
+func canSeeChat(player *states.PlayerState, line states.ChatLine) bool {
+	if player.HasMuted(line.ServerPlayerID) {
+		return false
+	}
+	return !line.TeamOnly || (!player.IsSpectator && states.TeamAtPosX(player.Pos.X) == line.Team)
+}
+
+// returns the message that passes a chat line on to clients
+func chatMessage(line states.ChatLine, gameID string, roomCode string) messages.ChatMessage {
+	return messages.ChatMessage{
+		ServerPlayerID: line.ServerPlayerID,
+		GameID:         gameID,
+		RoomCode:       roomCode,
+		Text:           line.Text,
+		TeamOnly:       line.TeamOnly,
+		DisplayName:    line.DisplayName,
+		SentAt:         line.SentAt,
+	}
+}
+
+// send a chat message to the connections of everyone in an instance who may see it
+func (s *ServerData) broadcastChat(r *states.RegisteredInstance, line states.ChatLine, gameID string, roomCode string) {
+	msg := chatMessage(line, gameID, roomCode)
+
+	// a connection shared by several players is sent the message once if any of them may see it
+	seen := make(map[string]bool)
+	sendTo := func(pid, _ any) bool {
+		player, err := s.FindPlayer(pid.(string))
+		if err != nil || !canSeeChat(player, line) {
+			return true
+		}
+		sessionID := player.GetSessionID()
+		if seen[sessionID] {
+			return true
+		}
+		seen[sessionID] = true
+		if sess, err := s.FindSession(sessionID); err == nil {
+			s.sendws(sess, msg)
+		}
+		return true
+	}
+	r.Players.Range(sendTo)
+	r.Spectators.Range(sendTo)
+}
+
+// send a player who joined an instance the recent chat messages that they may see
+func (s *ServerData) sendChatHistory(sess *Session, player *states.PlayerState, r *states.RegisteredInstance, gameID string, roomCode string) {
+	for _, line := range r.Chat.Lines() {
+		if canSeeChat(player, line) {
+			s.sendws(sess, chatMessage(line, gameID, roomCode))
+		}
+	}
+}
+
+// check a player's movement update against their previous action and stats, counting any violations
+// * returns the action to accept, with violations clamped, or false if the update is too far off and should be rejected
+func (s *ServerData) validatePlayerMovement(player *states.PlayerState, action states.PlayerAction) (states.PlayerAction, bool) {
+	var elapsed time.Duration
+	if last, ok := player.PosHistory.LatestTime(); ok {
+		elapsed = time.Since(last)
+	}
+	check := states.CheckMovement(player.PlayerAction, action, elapsed, player.PlayerAttributes)
+	if len(check.Violations) > 0 {
+		log.Printf("Movement violation by player %s: %s", player.GUID, check)
+		if player.Violations.Add(check.Violations) {
+			counts, total, _ := player.Violations.Counts()
+			log.Printf("Player %s (%s) has been flagged for moderation after %d movement violations: %v", player.GUID, player.DisplayName, total, counts)
+		}
+	}
+	return check.Action, !check.Rejected
 }
 
 // helper function to send data of all players in a game to a connection
-func (s *ServerData) sendGamePlayerIncludes(conn *websocket.Conn, r *states.RegisteredInstance) {
+func (s *ServerData) sendGamePlayerIncludes(sess *Session, r *states.RegisteredInstance) {
 	r.Players.Range(func(pid, _ interface{}) bool {
 		peer, err := s.FindPlayer(pid.(string))
 		if err != nil {
 			log.Printf("Could not find expected player in game with id %s, player id: %s", r.GUID, pid.(string))
+			return true
 		}
+		rtt, _ := peer.Latency.RTT()
 		includeMsg := messages.PlayerIncludeMessage{
 			Attributes:     peer.PlayerAttributes,
 			Action:         peer.PlayerAction,
 			ServerPlayerID: peer.GUID,
+			RTTMs:          rtt,
 		}
-		msg, err := structures.ToWrappedJSON(includeMsg)
-		if err != nil {
-			log.Printf("Unable to wrap PlayerIncludeMessage in a json: %s", err)
-		} else {
-			s.sendws(conn, msg)
-		}
+		s.sendws(sess, includeMsg)
 		return true
 	})
 }
@@ -159,76 +484,125 @@ func (s *ServerData) removePlayerGame(playerID string, gameID string) {
 		if err != nil {
 			log.Println(err)
 		} else {
-			msg, err := structures.ToWrappedJSON(messages.LeaveGameMessage{
+			// send an update to all players
+			s.broadcastws(messages.LeaveGameMessage{
 				PlayerServerID: playerID,
 				GameID:         gameID,
-			})
-			if err != nil {
-				log.Printf("Unable to send leave game message to game: %s", err)
-			} else {
-
-				// send an update to all players
-				s.broadcastws(msg, &game.RegisteredInstance)
-			}
+			}, &game.RegisteredInstance)
 
 			// remove from the instance's player map
 			game.RegisteredInstance.Players.Delete(playerID)
+			game.RegisteredInstance.Spectators.Delete(playerID)
+			game.RegisteredInstance.ForgetJoin(playerID)
 
-			// delete the instance if no players remain
-			if util.GetSyncMapSize(&game.RegisteredInstance.Players) == 0 {
+			// delete the instance if no players or spectators remain
+			if util.GetSyncMapSize(&game.RegisteredInstance.Players) == 0 && util.GetSyncMapSize(&game.RegisteredInstance.Spectators) == 0 {
 				s.Games.Delete(gameID)
+				if lobby, err := s.FindLobby(game.RoomCode); err == nil && lobby.GameID == gameID {
+					lobby.GameID = ""
+				}
 			} else {
 				s.assignHostIfLeave(&game.RegisteredInstance, playerID)
 			}
 
-			// remove from the global player map
-			s.Players.Delete(playerID)
+			// remove from the global player map, unless they are still in the lobby that the game was started from
+			player, err := s.FindPlayer(playerID)
+			if err == nil && len(player.RoomCode) > 0 && player.RoomCode == game.RoomCode {
+				player.GameID = ""
+			} else {
+				s.deletePlayer(playerID)
+			}
 
 		}
 	}
 }
 
+// returns an error if a player may not join a lobby, because the host banned them, the lobby is locked, or it is private and neither its password nor one of its invites was given
+// * players already in the lobby are not affected by its lock or password
+// * an invite is used up only if the player gets in with it, and the password is tried first so that an invite is not wasted
+func checkLobbyAdmission(lobby *states.LobbyState, player *states.PlayerState, sess *Session, password string, inviteToken string) error {
+	if lobby.IsBanned(player.GUID, sess.ID) {
+		return requestErrorf(messages.ErrCodeBanned, "player %s is banned from lobby %s", player.GUID, lobby.RoomCode)
+	}
+	if isPlayer, isSpectator := instanceRole(&lobby.RegisteredInstance, player.GUID); isPlayer || isSpectator {
+		return nil
+	}
+	if lobby.IsLocked() {
+		return requestErrorf(messages.ErrCodeLobbyLocked, "lobby %s is locked", lobby.RoomCode)
+	}
+	if !lobby.RequiresPassword() {
+		return nil
+	}
+	if err := checkLobbyAttempts(sess); err != nil {
+		return err
+	}
+	if lobby.CheckPassword(password) || (len(inviteToken) > 0 && lobby.RedeemInvite(inviteToken)) {
+		return nil
+	}
+	failLobbyAttempt(sess)
+	return requestErrorf(messages.ErrCodeBadPassword, "wrong password or invite for lobby %s", lobby.RoomCode)
+}
+
+// returns an error if a connection has failed to find or get into a lobby too often recently, so that room codes and passwords can't be guessed by brute force
+func checkLobbyAttempts(sess *Session) error {
+	if !sess.lobbyAttempts.Available(time.Now(), defs.LobbyAttemptBurst, defs.LobbyAttemptRefillSeconds*time.Second) {
+		return requestErrorf(messages.ErrCodeRateLimited, "too many failed attempts to find or join a lobby; wait before trying again")
+	}
+	return nil
+}
+
+// count a failed attempt by a connection to find or get into a lobby
+func failLobbyAttempt(sess *Session) {
+	sess.lobbyAttempts.Allow(time.Now(), defs.LobbyAttemptBurst, defs.LobbyAttemptRefillSeconds*time.Second)
+}
+
 // remove player from the specified lobby
-func (s *ServerData) removePlayerLobby(playerID string, roomCode string) {
+// * the reason is passed on to everyone in the lobby, including the player, when the server removes them (e.g. they were kicked); it is empty if they left on their own
+func (s *ServerData) removePlayerLobby(playerID string, roomCode string, reason string) {
 
 	if roomCode != "" {
 		lobby, err := s.FindLobby(roomCode)
 		if err != nil {
 			log.Println(err)
 		} else {
-			msg, err := structures.ToWrappedJSON(messages.LeaveLobbyMessage{
+			// send an update to all players
+			s.broadcastws(messages.LeaveLobbyMessage{
 				PlayerServerID: playerID,
 				RoomCode:       roomCode,
-			})
-			if err != nil {
-				log.Printf("Unable to send leave game message to lobby: %s", err)
-			} else {
+				Reason:         reason,
+			}, &lobby.RegisteredInstance)
 
-				// send an update to all players
-				s.broadcastws(msg, &lobby.RegisteredInstance)
+			// remove from the lobby's match, if they are playing in it
+			if player, err := s.FindPlayer(playerID); err == nil && len(player.GameID) > 0 && player.GameID == lobby.GameID {
+				s.removePlayerGame(playerID, player.GameID)
 			}
 
 			// remove from the instance's player map
 			lobby.RegisteredInstance.Players.Delete(playerID)
+			lobby.RegisteredInstance.Spectators.Delete(playerID)
+			lobby.RegisteredInstance.ForgetJoin(playerID)
+			lobby.Ready.Delete(playerID)
 
-			// remove from instance's player map
-			if util.GetSyncMapSize(&lobby.RegisteredInstance.Players) == 0 {
+			// remove from instance's player map if no players or spectators remain
+			if util.GetSyncMapSize(&lobby.RegisteredInstance.Players) == 0 && util.GetSyncMapSize(&lobby.RegisteredInstance.Spectators) == 0 {
+				lobby.CancelCountdown()
 				s.Lobbies.Delete(roomCode)
 			} else {
 				s.assignHostIfLeave(&lobby.RegisteredInstance, playerID)
+				s.evaluateReadyCheck(lobby)
 			}
 
 			// remove from the global player map
-			s.Players.Delete(playerID)
+			s.deletePlayer(playerID)
 		}
 	}
 }
 
 // handle loss of a connection for any reason
-func (s *ServerData) processdisconnect(conn *websocket.Conn) {
+// * players on the connection are kept for a grace period in which they can resume their session from a new connection
+func (s *ServerData) processdisconnect(sess *Session) {
 
 	// find players with matching connection
-	var lstRemove []string
 	s.Players.Range(func(pid, value interface{}) bool {
 
 		// ensure type
@@ -239,25 +613,94 @@ func (s *ServerData) processdisconnect(conn *websocket.Conn) {
 			return true // Continue the iteration
 		}
 
-		// check for matched address
-		if ptr.GetAddress().String() == conn.RemoteAddr().String() {
-
-			// this is the player that disconnected; mark for deletion
-			lstRemove = append(lstRemove, ptr.GUID)
+		// check for matched session
+		if ptr.GetSessionID() == sess.ID {
 
-			// remove from the player's game if it exists
-			gameID := ptr.GameID
-			s.removePlayerGame(ptr.GUID, gameID)
-
-			// remove from the player's lobby if it exists
-			roomCode := ptr.RoomCode
-			s.removePlayerLobby(ptr.GUID, roomCode)
+			// this is the player that disconnected; remove them if they don't come back in time
+			disconnectTime := ptr.MarkDisconnected()
+			log.Printf("Player %s disconnected; holding their session for %d seconds", ptr.GUID, defs.ResumeGraceSeconds)
+			go s.expireDisconnectedPlayer(ptr, disconnectTime)
 		}
 		return true
 	})
+}
+
+// remove a disconnected player from the server once their grace period has passed, unless they resumed their session in the meantime
+func (s *ServerData) expireDisconnectedPlayer(player *states.PlayerState, disconnectTime time.Time) {
+	time.Sleep(defs.ResumeGraceSeconds * time.Second)
+	s.removeDisconnectedPlayer(player, disconnectTime)
+}
+
+// remove a player whose grace period has passed from the server, unless they resumed their session since the given disconnection time
+func (s *ServerData) removeDisconnectedPlayer(player *states.PlayerState, disconnectTime time.Time) {
+	if !player.IsDisconnectedSince(disconnectTime) {
+		return
+	}
+	log.Printf("Removing player %s after their connection was lost", player.GUID)
+
+	// remove from the player's game and lobby if they exist
+	s.removePlayerGame(player.GUID, player.GameID)
+	s.removePlayerLobby(player.GUID, player.RoomCode, "")
 
-	// remove the player from the player map (or multiple in case multiple registries were made)
-	for _, pid := range lstRemove {
-		s.Players.Delete(pid)
+	// remove the player from the player map
+	s.deletePlayer(player.GUID)
+}
+
+// remove a player from the global player map, along with their resume token
+func (s *ServerData) deletePlayer(playerID string) {
+	if player, err := s.FindPlayer(playerID); err == nil {
+		s.ResumeTokens.Delete(player.GetResumeToken())
+	}
+	s.Players.Delete(playerID)
+}
+
+// issue a new resume token to a player, replacing any previous one, and return it
+func (s *ServerData) issueResumeToken(player *states.PlayerState) string {
+	token := util.RandomToken(16)
+	s.ResumeTokens.Delete(player.GetResumeToken())
+	player.SetResumeToken(token)
+	s.ResumeTokens.Store(token, player.GUID)
+	return token
+}
+
+// send a player the full state of the game and lobby they are in, e.g. after they resume their session
+func (s *ServerData) resyncPlayer(sess *Session, player *states.PlayerState) {
+
+	// send the host of an instance to the connection
+	sendHost := func(r *states.RegisteredInstance) {
+		s.sendws(sess, messages.SyncHostMessage{
+			HostID: r.HostID,
+		})
+	}
+
+	// resync the lobby
+	if lobby, err := s.FindLobby(player.RoomCode); err == nil {
+		if !player.IsSpectator {
+			s.sendws(sess, messages.ForcePlayerMessage{
+				Action:         player.PlayerAction,
+				ServerPlayerID: player.GUID,
+			})
+		}
+		s.sendCurrentBackdrop(sess, lobby)
+		s.sendGamePlayerIncludes(sess, &lobby.RegisteredInstance)
+		sendHost(&lobby.RegisteredInstance)
+		s.evaluateReadyCheck(lobby)
+	} else {
+		player.RoomCode = ""
+	}
+
+	// resync the game
+	if game, err := s.FindGame(player.GameID); err == nil {
+		s.sendGamePlayerIncludes(sess, &game.RegisteredInstance)
+		s.sendCurrentScore(sess, game)
+		sendHost(&game.RegisteredInstance)
+		if ball := game.GetBallCopy(); ball != nil {
+			s.sendws(sess, messages.BallStateMessage{
+				Ball:   *ball,
+				GameID: game.GUID,
+			})
+		}
+	} else {
+		player.GameID = ""
 	}
 }
@@ -1,9 +1,17 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/messages"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/states"
 	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/util"
 )
 
@@ -12,13 +20,176 @@ import (
 // handle the status route on http - returns some server metrics
 func (s *ServerData) HandleStatus(w http.ResponseWriter, r *http.Request) {
 	s.WriteHTTP(w, fmt.Sprintf("Server start time: %s \n", s.Info.StartTime))
-	s.WriteHTTP(w, fmt.Sprintf("Number of clients connected: %d\n", util.GetSyncMapSize(&(s.Connections))))
+	s.WriteHTTP(w, fmt.Sprintf("Number of clients connected: %d\n", util.GetSyncMapSize(&(s.Sessions))))
 	s.WriteHTTP(w, fmt.Sprintf("Number of players connected: %d \n", util.GetSyncMapSize(&(s.Players))))
 	s.WriteHTTP(w, fmt.Sprintf("Number of active lobbies: %d \n", util.GetSyncMapSize(&(s.Lobbies))))
 	s.WriteHTTP(w, fmt.Sprintf("Number of active games: %d \n", util.GetSyncMapSize(&(s.Games))))
 	s.WriteHTTP(w, fmt.Sprintf("Number of requests processed: %d \n", s.Info.ReqCount))
 	s.WriteHTTP(w, fmt.Sprintf("Estimated data received: %s \n", util.FormatBytes(s.Info.BytesReceived)))
 	s.WriteHTTP(w, fmt.Sprintf("Estimated data sent: %s \n", util.FormatBytes(s.Info.BytesSent)))
+
+	// list the bandwidth used by each instance, to compare instances that send snapshots against those that relay every action
+	writeInstance := func(kind string, id string, r *states.RegisteredInstance) {
+		mode := "actions relayed"
+		if r.SnapshotHz > 0 {
+			mode = fmt.Sprintf("snapshots at %d Hz", r.SnapshotHz)
+		}
+		s.WriteHTTP(w, fmt.Sprintf("  %s %s: %d players, %s, %s broadcast \n", kind, id, util.GetSyncMapSize(&r.Players), mode, util.FormatBytes(r.GetBytesSent())))
+	}
+	s.Lobbies.Range(func(_, value any) bool {
+		if lobby, ok := value.(*states.LobbyState); ok {
+			writeInstance("Lobby", lobby.RoomCode, &lobby.RegisteredInstance)
+		}
+		return true
+	})
+	s.Games.Range(func(_, value any) bool {
+		if game, ok := value.(*states.GameState); ok {
+			writeInstance("Game", game.GUID, &game.RegisteredInstance)
+		}
+		return true
+	})
+
+	// summarize the measured round trip times, for players whose clients ping on their behalf, without saying who they belong to
+	rtts := []float64{}
+	s.Players.Range(func(_, value any) bool {
+		if player, ok := value.(*states.PlayerState); ok {
+			if player.Latency.Measured() {
+				rtt, _ := player.Latency.RTT()
+				rtts = append(rtts, rtt)
+			}
+		}
+		return true
+	})
+	if len(rtts) > 0 {
+		sort.Float64s(rtts)
+		total := 0.0
+		for _, rtt := range rtts {
+			total += rtt
+		}
+		p95 := rtts[(len(rtts)*95+99)/100-1]
+		s.WriteHTTP(w, fmt.Sprintf("Round trip times measured: %d, %.0f ms mean, %.0f ms p95 \n", len(rtts), total/float64(len(rtts)), p95))
+	}
+}
+
+// handle the messages route on http - lists every message type that clients may send over websockets, along with its fields
+func (s *ServerData) HandleMessageTypes(w http.ResponseWriter, r *http.Request) {
+	data, err := json.MarshalIndent(ListMessageTypes(), "", "  ")
+	if err != nil {
+		log.Printf("Unable to list message types: %s", err)
+		http.Error(w, "Unable to list message types", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	s.WriteHTTP(w, string(data))
+}
+
+// handle the emotes route on http - lists the emotes that players may fire
+func (s *ServerData) HandleEmotes(w http.ResponseWriter, r *http.Request) {
+	data, err := json.MarshalIndent(s.Emotes.List(), "", "  ")
+	if err != nil {
+		log.Printf("Unable to list emotes: %s", err)
+		http.Error(w, "Unable to list emotes", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	s.WriteHTTP(w, string(data))
+}
+
+// list a page of the public lobbies on the server that pass the filters of a request, with the most players first
+// * returns the request with its paging settled and the listings filled in
+func (s *ServerData) ListPublicLobbies(rq messages.ListLobbiesMessage) messages.ListLobbiesMessage {
+
+	// settle the page to list
+	if rq.PageSize <= 0 {
+		rq.PageSize = defs.LobbyBrowserPageSize
+	}
+	rq.PageSize = min(rq.PageSize, defs.MaxLobbyBrowserPageSize)
+	rq.Page = max(rq.Page, 0)
+
+	// collect the lobbies that anyone may join and that pass the filters
+	list := []messages.LobbyListing{}
+	s.Lobbies.Range(func(_, value any) bool {
+		lobby, ok := value.(*states.LobbyState)
+		if !ok || !lobby.IsPublic || lobby.IsLocked() {
+			return true
+		}
+		listing := messages.LobbyListing{
+			RoomCode:         lobby.RoomCode,
+			Backdrop:         lobby.Backdrop,
+			NumPlayers:       util.GetSyncMapSize(&lobby.Players),
+			MaxPlayers:       2 * defs.MaxTeamPlayers,
+			NumSpectators:    util.GetSyncMapSize(&lobby.Spectators),
+			InMatch:          s.isLobbyInMatch(lobby),
+			RequiresPassword: lobby.RequiresPassword(),
+		}
+		if host, err := s.FindPlayer(lobby.HostID); err == nil {
+			listing.HostDisplayName = host.DisplayName
+		}
+		_, hasOpenSlot := s.findOpenTeam(&lobby.RegisteredInstance)
+		if (len(rq.Backdrop) > 0 && rq.Backdrop != listing.Backdrop) || (rq.HideFull && !hasOpenSlot) || (rq.HideInMatch && listing.InMatch) || (rq.HidePrivate && listing.RequiresPassword) {
+			return true
+		}
+		list = append(list, listing)
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].NumPlayers != list[j].NumPlayers {
+			return list[i].NumPlayers > list[j].NumPlayers
+		}
+		return list[i].RoomCode < list[j].RoomCode
+	})
+
+	// cut out the page; pages past the end are empty, and are checked before multiplying so that a huge page can't overflow
+	rq.Total = len(list)
+	start := len(list)
+	if rq.Page <= len(list)/rq.PageSize {
+		start = min(rq.Page*rq.PageSize, len(list))
+	}
+	end := min(start+rq.PageSize, len(list))
+	rq.Lobbies = list[start:end]
+	return rq
+}
+
+// handle the lobbies route on http - lists a page of the public lobbies, filtered by the query parameters
+// * e.g. /lobbies?page=0&pageSize=20&backdrop=beach&hideFull=true&hideInMatch=true&hidePrivate=true
+func (s *ServerData) HandleLobbies(w http.ResponseWriter, r *http.Request) {
+	rq, err := parseLobbyListQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := json.MarshalIndent(s.ListPublicLobbies(rq), "", "  ")
+	if err != nil {
+		log.Printf("Unable to list lobbies: %s", err)
+		http.Error(w, "Unable to list lobbies", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	s.WriteHTTP(w, string(data))
+}
+
+// read a request for a page of public lobbies from the query parameters of a url; missing parameters are left at their defaults
+func parseLobbyListQuery(query url.Values) (messages.ListLobbiesMessage, error) {
+	rq := messages.ListLobbiesMessage{Backdrop: query.Get("backdrop")}
+	for name, value := range map[string]*int{"page": &rq.Page, "pageSize": &rq.PageSize} {
+		if query.Has(name) {
+			n, err := strconv.Atoi(query.Get(name))
+			if err != nil {
+				return rq, fmt.Errorf("invalid %s: %s", name, query.Get(name))
+			}
+			*value = n
+		}
+	}
+	for name, value := range map[string]*bool{"hideFull": &rq.HideFull, "hideInMatch": &rq.HideInMatch, "hidePrivate": &rq.HidePrivate} {
+		if query.Has(name) {
+			b, err := strconv.ParseBool(query.Get(name))
+			if err != nil {
+				return rq, fmt.Errorf("invalid %s: %s", name, query.Get(name))
+			}
+			*value = b
+		}
+	}
+	return rq, nil
 }
 
 // return an empty page
@@ -11,6 +11,7 @@ import (
 
 	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
 	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/states"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/structures"
 
 	"github.com/gorilla/websocket"
 )
@@ -31,62 +32,70 @@ func (s *ServerData) HandleWS(w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err)
+		return
 	}
-	log.Printf("[%s] Client connected", r.RemoteAddr)
 
-	// store the connection to the map
-	clientAddr := conn.RemoteAddr().String()
-	s.Connections.LoadOrStore(clientAddr, conn)
+	// open a session for the connection and store it to the map
+	sess := NewSession(conn, defs.SendQueueSize, s.SlowClientPolicy)
+	s.Sessions.LoadOrStore(sess.ID, sess)
+	log.Printf("[%s] Client connected", sess)
+
+	// start writing to this client's connection
+	go sess.writepump()
 
 	// send a verification message to the client
-	verifMsg := fmt.Sprintf("Server registry of client %s successful!", clientAddr)
-	s.sendws(conn, []byte(verifMsg))
+	verifMsg := fmt.Sprintf("Server registry of client %s successful!", sess.Remote)
+	s.queuews(sess, []byte(verifMsg), false, false)
 
 	// start reading from this client's connection
-	go s.readerws(conn)
+	go s.readerws(sess)
 }
 
 // close the websocket connection
-func (s *ServerData) closews(conn *websocket.Conn) {
-	conn.Close()
-	s.Connections.CompareAndDelete(conn.RemoteAddr().String(), conn)
-	log.Printf("[%s] Websocket listener stopped", conn.RemoteAddr())
+func (s *ServerData) closews(sess *Session) {
+	sess.Close()
+	s.Sessions.CompareAndDelete(sess.ID, sess)
+	log.Printf("[%s] Websocket listener stopped", sess)
 }
 
 // listener for messages received from websocket connections
-func (s *ServerData) readerws(conn *websocket.Conn) {
+func (s *ServerData) readerws(sess *Session) {
+	conn := sess.conn
 
 	// define a panic handling function
 	defer func() {
 
 		// handle panic
 		if r := recover(); r != nil {
-			log.Printf("[%s] Panic during websocket listener: %v", conn.RemoteAddr(), r)
+			log.Printf("[%s] Panic during websocket listener: %v", sess, r)
 		}
 
-		// close the connection
-		s.closews(conn)
+		// close the connection, and hold on to its players in case they come back
+		s.closews(sess)
+		s.processdisconnect(sess)
 	}()
 
-	// handle disconnection error
+	// log why the connection was lost
 	logMessageErr := func(err error) {
-		if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+		if sess.IsClosed() {
+
+			log.Printf("[%s] Connection closed by server", sess)
 
-			log.Printf("[%s] Unexpected close error: %v", conn.RemoteAddr(), err)
-			s.processdisconnect(conn)
+		} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+
+			log.Printf("[%s] Unexpected close error: %v", sess, err)
 
 		} else if errors.Is(err, io.EOF) {
 
-			log.Printf("[%s] Connection closed by client", conn.RemoteAddr())
-			s.processdisconnect(conn)
+			log.Printf("[%s] Connection closed by client", sess)
 
 		} else if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
 
-			log.Printf("[%s] Read timeout: %v", conn.RemoteAddr(), err)
+			log.Printf("[%s] Read timeout: %v", sess, err)
 
 		} else {
 
-			log.Printf("[%s] Error reading message: %v", conn.RemoteAddr(), err)
+			log.Printf("[%s] Error reading message: %v", sess, err)
 
 		}
 	}
@@ -99,7 +108,7 @@ func (s *ServerData) readerws(conn *websocket.Conn) {
 
 		// handle timeout timer
 		if time.Since(timeLastMsgReceived).Minutes() > defs.TimeoutPlayerMinutesWS {
-			log.Printf("[%s] Timeout due to no requests received after a long time", conn.RemoteAddr())
+			log.Printf("[%s] Timeout due to no requests received after a long time", sess)
 			break
 		}
 
@@ -118,36 +127,94 @@ func (s *ServerData) readerws(conn *websocket.Conn) {
 		}
 
 		// parse it
-		msg, err := parsews(msgType, msgBody)
+		codec, msg, err := parsews(msgType, msgBody)
 		if err != nil {
-			log.Printf("Unable to parse a message {%s} from %s: %v", msg, conn.RemoteAddr(), err)
+			log.Printf("Unable to parse a message {%s} from %s: %v", msgBody, sess, err)
 			continue
 		}
-		log.Printf("[<-%s] %s", conn.RemoteAddr(), msg)
+		log.Printf("[<-%s] %s", sess, describews(codec, msg))
 
 		// process it
-		res, err := s.processws(conn, msg)
+		in := &inbound{sess: sess, codec: codec, body: msg, received: timeLastMsgReceived}
+		res, err := s.processws(in)
 		if err != nil {
-			log.Printf("Unable to process a message {%s} from %s: %v", msg, conn.RemoteAddr(), err)
-			continue
+			log.Printf("Unable to process a message {%s} from %s: %v", describews(codec, msg), sess, err)
 		}
 
-		// send a result message
-		s.sendws(conn, res)
+		// send a result message, or the error back to the client
+		s.replyws(sess, res, in.requestID)
+	}
+}
+
+// encode a message with the session's codec and queue it to be sent to the session's websocket connection
+func (s *ServerData) sendws(sess *Session, msg any) {
+	s.replyws(sess, msg, "")
+}
+
+// encode a message that answers the request with the given id, and queue it to be sent to the session's websocket connection
+func (s *ServerData) replyws(sess *Session, msg any, requestID string) {
+	s.encodews(sess, msg, requestID, false)
+}
+
+// encode a message that is superseded by later updates (e.g. snapshots), and queue it to be sent to the session's websocket connection
+// * returns the number of bytes queued; the message may be dropped if the client falls behind
+func (s *ServerData) sendwsTransient(sess *Session, msg any) uint64 {
+	return s.encodews(sess, msg, "", true)
+}
+
+// encode a message with the session's codec and queue it on the session, returning the number of bytes queued
+func (s *ServerData) encodews(sess *Session, msg any, requestID string, transient bool) uint64 {
+	if msg == nil {
+		return 0
 	}
+	codec := sess.Codec()
+	msgBody, err := codec.Encode(msg, requestID)
+	if err != nil {
+		log.Printf("Unable to encode %T for %s: %s", msg, sess, err)
+		return 0
+	}
+	return s.queuews(sess, msgBody, transient, codec.IsBinary())
 }
 
-// send a message to the specified websocket connection
-func (s *ServerData) sendws(conn *websocket.Conn, msgBody []byte) {
+// queue an encoded message on a session, returning immediately; transient messages may be dropped if the client falls behind
+// * returns the number of bytes that the message will take up on the wire, or zero if it was not queued
+func (s *ServerData) queuews(sess *Session, msgBody []byte, transient bool, binary bool) uint64 {
 	if msgBody == nil {
-		return
+		return 0
 	}
-	s.Info.CountBytesSent(uint64(overheadsendws(msgBody) + len(msgBody)))
-	if err := conn.WriteMessage(websocket.TextMessage, msgBody); err != nil {
-		log.Println(err)
+	if !sess.enqueue(outboundFrame{body: msgBody, transient: transient, binary: binary}) {
+		return 0
+	}
+	numBytes := uint64(overheadsendws(msgBody) + len(msgBody))
+	s.Info.CountBytesSent(numBytes)
+	if binary {
+		log.Printf("[->%s] %s", sess, describews(structures.BinaryCodec, msgBody))
 	} else {
-		log.Printf("[->%s] %s", conn.RemoteAddr().String(), msgBody)
+		log.Printf("[->%s] %s", sess, describews(structures.JSONCodec, msgBody))
 	}
+	return numBytes
+}
+
+// returns a printable description of an encoded message for logging; binary messages are described by their type and size
+// * messages that may carry lobby passwords, invite tokens or resume tokens are described the same way, so that the secrets stay out of the logs
+func describews(codec structures.Codec, msgBody []byte) string {
+	header, err := codec.Header(msgBody)
+	if err != nil {
+		header.Type = "unknown"
+	}
+	if !codec.IsBinary() && !secretMessageTags[messageTag(header.Type)] {
+		return string(msgBody)
+	}
+	return fmt.Sprintf("<%s %s, %d bytes>", codec.Name(), header.Type, len(msgBody))
+}
+
+// the tags of message types that may carry lobby passwords, invite tokens or resume tokens
+// * admissions carry resume tokens both ways: the client presents one to resume a session, and the server hands out the next one
+var secretMessageTags = map[string]bool{
+	"admission":        true,
+	"addplayerlobby":   true,
+	"setlobbypassword": true,
+	"createinvite":     true,
 }
 
 // returns the number of bytes of overhead bandwidth used to send a message via websockets
@@ -167,26 +234,62 @@ func overheadreceivews(msgBody []byte) int {
 	return overheadsendws(msgBody) + 4
 }
 
-// parse a message received from a websocket connection
-func parsews(msgType int, msgBody []byte) ([]byte, error) {
+// parse a message received from a websocket connection, and return the codec it is encoded with
+// * text frames are always json, and binary frames are always in the binary encoding, regardless of what the session negotiated for its outgoing messages
+func parsews(msgType int, msgBody []byte) (structures.Codec, []byte, error) {
 	switch msgType {
 	case websocket.TextMessage:
-		return msgBody, nil
-	//case websocket.BinaryMessage: // todo: implement?
+		return structures.JSONCodec, msgBody, nil
+	case websocket.BinaryMessage:
+		return structures.BinaryCodec, msgBody, nil
 	default:
-		return nil, fmt.Errorf("unsupported message type: %d", msgType)
+		return nil, nil, fmt.Errorf("unsupported message type: %d", msgType)
 	}
 }
 
 // send a broadcast message to all clients connected to the specified game
-func (s *ServerData) broadcastws(msgBody []byte, r *states.RegisteredInstance) {
+func (s *ServerData) broadcastws(msg any, r *states.RegisteredInstance) {
+	s.broadcast(msg, r, false)
+}
 
-	log.Printf("[->inst=%s]: %s", r.GUID, msgBody)
+// send a broadcast message that is superseded by later updates (e.g. player actions) to all clients connected to the specified game
+// * clients that have fallen behind may have these messages dropped
+func (s *ServerData) broadcastwsTransient(msg any, r *states.RegisteredInstance) {
+	s.broadcast(msg, r, true)
+}
 
-	// get a list of unique addresses so that messages aren't getting duplicated to the same client
-	addresses := []net.Addr{}
-	seen := make(map[net.Addr]bool)
-	r.Players.Range(func(key, value any) bool {
+// queue a message on the session of every player and spectator in the specified game, without waiting for them to be sent
+// * the message is encoded at most once per codec in use by the recipients
+func (s *ServerData) broadcast(msg any, r *states.RegisteredInstance, transient bool) {
+
+	log.Printf("[->inst=%s]: %T", r.GUID, msg)
+
+	// for each player connected to the game, send the message to the corresponding client
+	encoded := make(map[string][]byte)
+	for _, sess := range s.instanceSessions(r) {
+
+		// encode the message the first time that its codec is needed
+		codec := sess.Codec()
+		msgBody, ok := encoded[codec.Name()]
+		if !ok {
+			var err error
+			msgBody, err = codec.Encode(msg, "")
+			if err != nil {
+				log.Printf("Unable to encode %T for broadcast: %s", msg, err)
+			}
+			encoded[codec.Name()] = msgBody
+		}
+		r.CountBytesSent(s.queuews(sess, msgBody, transient, codec.IsBinary()))
+	}
+}
+
+// returns the sessions of every player and spectator in the specified game, without duplicates
+func (s *ServerData) instanceSessions(r *states.RegisteredInstance) []*Session {
+
+	// get a list of unique sessions of players and spectators so that messages aren't getting duplicated to the same client
+	sessionIDs := []string{}
+	seen := make(map[string]bool)
+	collectSession := func(key, value any) bool {
 
 		// get the player ID
 		playerID, ok := key.(string)
@@ -201,28 +304,26 @@ func (s *ServerData) broadcastws(msgBody []byte, r *states.RegisteredInstance) {
 			log.Printf("could not find player id in registry (perhaps they have disconnected?): %s", playerID)
 		} else {
 
-			// add the player to the list if the address is distinct
-			addr := ptr.GetAddress()
-			if !seen[addr] {
-				seen[addr] = true
-				addresses = append(addresses, addr)
+			// add the player's session to the list if it is distinct
+			sessionID := ptr.GetSessionID()
+			if !seen[sessionID] {
+				seen[sessionID] = true
+				sessionIDs = append(sessionIDs, sessionID)
 			}
 		}
 		return true
-	})
+	}
+	r.Players.Range(collectSession)
+	r.Spectators.Range(collectSession)
 
-	// for each player connected to the game, send the message to the corresponding client
-	for _, addr := range addresses {
-		conn, found := s.Connections.Load(addr.String())
-		if found {
-			wsConn, ok := conn.(*websocket.Conn)
-			if !ok {
-				log.Printf("connection map item not of type websocket.Conn: %s", addr.String())
-				return
-			}
-			s.sendws(wsConn, msgBody)
+	sessions := []*Session{}
+	for _, sessionID := range sessionIDs {
+		sess, err := s.FindSession(sessionID)
+		if err != nil {
+			log.Printf("client not found: %s", err)
 		} else {
-			log.Printf("client not found: %s", addr.String())
+			sessions = append(sessions, sess)
 		}
 	}
+	return sessions
 }
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/messages"
+)
+
+// Purpose: The registry of every type of message that clients may send to the server
+// * Each message type is registered with its tag, the struct it is decoded into, and the handler that processes it
+// * A tag is the message's struct name in lowercase without its namespace and "Message" suffix (e.g. "messages.SwitchSideMessage" -> "switchside"); the type name sent by a client is normalized the same way and must match a tag exactly
+
+// a registered message type
+type registeredMessage struct {
+	tag         string
+	goType      reflect.Type
+	description string
+	handle      func(s *ServerData, in *inbound) (any, error)
+}
+
+// the description of a registered message type, as published to client developers
+type MessageTypeInfo struct {
+	Tag         string             `json:"Tag"`
+	TypeName    string             `json:"TypeName"`
+	Description string             `json:"Description"`
+	Fields      []MessageFieldInfo `json:"Fields"`
+}
+
+// the description of a single field of a message type
+type MessageFieldInfo struct {
+	Name string `json:"Name"`
+	Type string `json:"Type"`
+}
+
+// all message types that clients may send, in the order they are listed to client developers
+var messageRegistry = registerMessageTypes(
+	registerMessage("synchronize the client's clock with the server and measure the round trip time of a player", (*ServerData).handleping),
+	registerMessage("register a player on the server; may resume a previous session or choose the encoding of messages sent back", (*ServerData).handleadmitplayer),
+	registerMessage("create a game, with optional match rules", (*ServerData).handlecreategame),
+	registerMessage("create a lobby and receive its room code; the lobby may be listed publicly", (*ServerData).handlecreatelobby),
+	registerMessage("check whether a lobby with a room code exists, and whether it needs a password to join", (*ServerData).handlechecklobby),
+	registerMessage("list a page of the public lobbies, optionally filtered, to find one to join", (*ServerData).handlelistlobbies),
+	registerMessage("join a game as a player or spectator", (*ServerData).handleaddplayergame),
+	registerMessage("join a lobby as a player or spectator, with its password or an invite if it is private", (*ServerData).handleaddplayerlobby),
+	registerMessage("leave a lobby", (*ServerData).handleleavelobby),
+	registerMessage("leave a game", (*ServerData).handleleavegame),
+	registerMessage("change the backdrop of a lobby", (*ServerData).handlesetbackdrop),
+	registerMessage("switch a player to the other side of the court in a lobby", (*ServerData).handleswitch),
+	registerMessage("mark a player as ready or not ready to start the lobby's match", (*ServerData).handleready),
+	registerMessage("configure the lobby's next match (host only)", (*ServerData).handlematchsettings),
+	registerMessage("bring everyone in a game back to its lobby (host only)", (*ServerData).handlereturnlobby),
+	registerMessage("promote a spectator to a player (host only)", (*ServerData).handlepromotespectator),
+	registerMessage("set or remove the password that players need to join the lobby (host only)", (*ServerData).handlesetlobbypassword),
+	registerMessage("create a one-time invite that lets a player join the lobby without its password (host only)", (*ServerData).handlecreateinvite),
+	registerMessage("hand off host status to another player in the lobby or game (host only)", (*ServerData).handletransferhost),
+	registerMessage("remove a player from the lobby, optionally banning them from rejoining it (host only)", (*ServerData).handlekickplayer),
+	registerMessage("lock or unlock the lobby against new joins (host only)", (*ServerData).handlelocklobby),
+	registerMessage("update a player's movement and animation", (*ServerData).handleplayeraction),
+	registerMessage("acknowledge a snapshot, so that the next ones are sent as changes against it", (*ServerData).handlesnapshotack),
+	registerMessage("serve or touch the game ball", (*ServerData).handleballevent),
+	registerMessage("send a chat message to everyone in a lobby or game, or only to the sender's team", (*ServerData).handlechat),
+	registerMessage("mute or unmute another player's chat messages", (*ServerData).handlemute),
+	registerMessage("fire an emote from the server's catalog above the player", (*ServerData).handleemote),
+)
+
+// create a registry entry for a message type, whose handler receives the decoded message
+func registerMessage[T any](description string, handler func(s *ServerData, in *inbound, rq T) (any, error)) *registeredMessage {
+	goType := reflect.TypeFor[T]()
+	return &registeredMessage{
+		tag:         messageTag(goType.String()),
+		goType:      goType,
+		description: description,
+		handle: func(s *ServerData, in *inbound) (any, error) {
+			var rq T
+			if err := in.decode(&rq); err != nil {
+				return nil, &requestError{code: messages.ErrCodeBadRequest, err: fmt.Errorf("unable to decode %s: %w", goType, err)}
+			}
+			return handler(s, in, rq)
+		},
+	}
+}
+
+// collect registry entries into a registry; panics if two message types share a tag, since one of them could never be received
+func registerMessageTypes(entries ...*registeredMessage) []*registeredMessage {
+	seen := make(map[string]reflect.Type)
+	for _, entry := range entries {
+		if other, ok := seen[entry.tag]; ok {
+			panic(fmt.Sprintf("message types %s and %s share the tag %q", other, entry.goType, entry.tag))
+		}
+		seen[entry.tag] = entry.goType
+	}
+	return entries
+}
+
+// returns the tag of a message type name, e.g. "messages.PingMessage" -> "ping"
+func messageTag(typeName string) string {
+	tag := strings.ToLower(typeName)
+	tag = tag[strings.LastIndexAny(tag, ".+")+1:] // drop namespaces, including those of nested c# classes
+	return strings.TrimSuffix(tag, "message")
+}
+
+// find the registered message type matching a type name received from a client
+func lookupMessageType(typeName string) (*registeredMessage, bool) {
+	tag := messageTag(typeName)
+	for _, entry := range messageRegistry {
+		if entry.tag == tag {
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+// list all message types that clients may send
+func ListMessageTypes() []MessageTypeInfo {
+	list := make([]MessageTypeInfo, 0, len(messageRegistry))
+	for _, entry := range messageRegistry {
+		info := MessageTypeInfo{
+			Tag:         entry.tag,
+			TypeName:    entry.goType.String(),
+			Description: entry.description,
+			Fields:      []MessageFieldInfo{},
+		}
+		for i := 0; i < entry.goType.NumField(); i++ {
+			field := entry.goType.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if len(name) == 0 {
+				name = field.Name
+			}
+			info.Fields = append(info.Fields, MessageFieldInfo{Name: name, Type: field.Type.String()})
+		}
+		list = append(list, info)
+	}
+	return list
+}
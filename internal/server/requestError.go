@@ -0,0 +1,44 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/messages"
+)
+
+// Purpose: Errors returned by request handlers, which are reported back to the client that made the request
+// * Each error carries one of the codes defined in the messages package, so that the client can tell failures apart (e.g. "room not found" vs "lobby full")
+
+// an error in processing a request, along with its code
+type requestError struct {
+	code string
+	err  error
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+// create a request error with the specified code and a formatted description
+func requestErrorf(code string, format string, args ...any) error {
+	return &requestError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+// build the message that reports a failed request back to the client; errors without a code are reported as internal errors
+func newErrorMessage(err error, requestType string) messages.ErrorMessage {
+	code := messages.ErrCodeInternal
+	var rqErr *requestError
+	if errors.As(err, &rqErr) {
+		code = rqErr.code
+	}
+	return messages.ErrorMessage{
+		Code:        code,
+		ErrMsg:      err.Error(),
+		RequestType: requestType,
+	}
+}
@@ -1,24 +1,46 @@
 package server
 
 import (
+	"log"
 	"sync"
+
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/emotes"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/filter"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/states"
 )
 
 // Purpose: A container for all the data tracked by the server in real time
 
 type ServerData struct {
-	Info        ServerState // vitals
-	Games       sync.Map    // a map of all ongoing games hosted on this server (key: game.GUID, value: *states.gameState)
-	Lobbies     sync.Map    // a map of all ongoing lobbies hosted on this server (key: lobby.RoomCode, value: *states.lobbyState)
-	Connections sync.Map    // a map of all live connections established on this server (key: conn.RemoteAddr(), value: *websocket.Conn)
-	Players     sync.Map    // a map of all connected clients hosted on this server (key: player.GUID, value: *states.playerState)
+	Info             ServerState            // vitals
+	SlowClientPolicy SlowClientPolicy       // how to handle clients whose send queue fills up
+	HostElection     HostElection           // how a new host is chosen when the current one leaves
+	AttributePolicy  states.AttributePolicy // the limits that players' attributes must be within to be admitted
+	WordFilter       filter.Filter          // the filter for blocked words in players' display names and chat; nil if nothing is filtered
+	NamePolicy       filter.Policy          // what to do with display names that contain blocked words
+	Emotes           *emotes.Catalog        // the emotes that players may fire
+	Games            sync.Map               // a map of all ongoing games hosted on this server (key: game.GUID, value: *states.gameState)
+	Lobbies          sync.Map               // a map of all ongoing lobbies hosted on this server (key: lobby.RoomCode, value: *states.lobbyState)
+	Sessions         sync.Map               // a map of all live connection sessions established on this server (key: session.ID, value: *Session)
+	Players          sync.Map               // a map of all connected clients hosted on this server (key: player.GUID, value: *states.playerState)
+	ResumeTokens     sync.Map               // a map of the tokens that let players resume their session after a disconnect (key: token, value: player.GUID)
 }
 
 // constructor function to initialize ServerData
 func NewServerData() *ServerData {
 	serverData := &ServerData{
-		Info: *NewServerState(), // Initialize Info field with zero value
+		Info:            *NewServerState(), // Initialize Info field with zero value
+		AttributePolicy: states.DefaultAttributePolicy(),
+		Emotes:          emotes.NewCatalog(emotes.DefaultEmotes()),
+	}
+
+	// choose how to handle clients that can't keep up
+	policy, ok := SlowClientPolicyByName(defs.SlowClientPolicyName)
+	if !ok {
+		log.Printf("Unknown slow client policy %q; dropping transient frames instead", defs.SlowClientPolicyName)
 	}
+	serverData.SlowClientPolicy = policy
 	return serverData
 }
 
@@ -3,6 +3,7 @@ package server
 import (
 	"fmt"
 
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/messages"
 	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/states"
 )
 
@@ -54,6 +55,23 @@ func (s *ServerData) FindLobby(roomCode string) (*states.LobbyState, error) {
 	return lobby, nil
 }
 
+// searches for a connection session by its ID and returns the Session if found, or nil along with an error if not.
+func (s *ServerData) FindSession(id string) (*Session, error) {
+
+	// look up the ID in the map
+	value, exists := s.Sessions.Load(id)
+	if !exists {
+		return nil, fmt.Errorf("session not found with ID %s", id)
+	}
+
+	// attempt to cast it to what it should be, in order to return the correct object
+	sess, ok := value.(*Session)
+	if !ok {
+		return nil, fmt.Errorf("value is not of type *Session")
+	}
+	return sess, nil
+}
+
 // searches for a player by its ID and returns the PlayerState if found, or nil along with an error if not.
 func (s *ServerData) FindPlayer(id string) (*states.PlayerState, error) {
 
@@ -76,3 +94,47 @@ func (s *ServerData) FindPlayer(id string) (*states.PlayerState, error) {
 	// return a pointer to the found object and nil error
 	return player, nil
 }
+
+// searches for the game with the given ID, or otherwise the lobby with the given room code, and returns its RegisteredInstance if found
+func (s *ServerData) FindInstance(gameID string, roomCode string) (*states.RegisteredInstance, error) {
+	if len(gameID) > 0 {
+		game, err := s.FindGame(gameID)
+		if err != nil {
+			return nil, &requestError{code: messages.ErrCodeGameNotFound, err: err}
+		}
+		return &game.RegisteredInstance, nil
+	}
+	if len(roomCode) > 0 {
+		lobby, err := s.FindLobby(roomCode)
+		if err != nil {
+			return nil, &requestError{code: messages.ErrCodeRoomNotFound, err: err}
+		}
+		return &lobby.RegisteredInstance, nil
+	}
+	return nil, requestErrorf(messages.ErrCodeBadRequest, "no game id or room code specified")
+}
+
+// searches for the player making a request, who must be attached to the session that the request arrived on, and returns the PlayerState if found
+func (s *ServerData) FindSessionPlayer(sess *Session, playerID string) (*states.PlayerState, error) {
+	player, err := s.FindPlayer(playerID)
+	if err != nil {
+		return nil, &requestError{code: messages.ErrCodePlayerNotFound, err: err}
+	}
+	if player.GetSessionID() != sess.ID {
+		return nil, requestErrorf(messages.ErrCodeBadRequest, "player %s is not attached to this connection", playerID)
+	}
+	return player, nil
+}
+
+// searches for the player that was issued the given resume token and returns the PlayerState if found, or nil along with an error if not.
+func (s *ServerData) FindPlayerByResumeToken(token string) (*states.PlayerState, error) {
+
+	// look up the token in the map
+	value, exists := s.ResumeTokens.Load(token)
+	if !exists {
+		return nil, fmt.Errorf("resume token not found")
+	}
+
+	// find the player that it belongs to
+	return s.FindPlayer(value.(string))
+}
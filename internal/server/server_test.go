@@ -1,7 +1,24 @@
 package server
 
 import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/emotes"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/filter"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/messages"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/states"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/structures"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 )
 
 // spam the server with concurrent requests until it reaches the limit
@@ -14,3 +31,1528 @@ func TestLimitRequests(t *testing.T) {
 	<-ss.ShutdownCh // block until the shutdown signal is received
 	t.Logf("Shutdown channel successfully closed with %d requests", ss.ReqCount)
 }
+
+// fill a session's send queue and check that transient frames are dropped to make room for others
+func TestSessionDropsTransientFrames(t *testing.T) {
+	sess := &Session{
+		ID:        "test-session",
+		policy:    DropTransientFrames,
+		queueSize: 2,
+		notify:    make(chan struct{}, 1),
+		closed:    make(chan struct{}),
+	}
+	sess.enqueue(outboundFrame{body: []byte("action1"), transient: true})
+	sess.enqueue(outboundFrame{body: []byte("host"), transient: false})
+	if !sess.enqueue(outboundFrame{body: []byte("score"), transient: false}) {
+		t.Fatalf("non-transient frame was not queued while a transient frame could be dropped")
+	}
+	if sess.enqueue(outboundFrame{body: []byte("action2"), transient: true}) {
+		t.Errorf("transient frame was queued into a full queue with nothing to drop")
+	}
+	if len(sess.queue) != 2 || string(sess.queue[0].body) != "host" || string(sess.queue[1].body) != "score" {
+		t.Errorf("queue = %v; want [host score]", sess.queue)
+	}
+	if sess.DroppedFrames() != 2 {
+		t.Errorf("dropped frames = %d; want 2", sess.DroppedFrames())
+	}
+}
+
+// check that the server's slow client policy is looked up by name, and that a disconnecting session closes once its queue is full
+func TestSessionDisconnectsSlowClient(t *testing.T) {
+	if policy, ok := SlowClientPolicyByName(defs.SlowClientPolicyName); !ok || NewServerData().SlowClientPolicy != policy {
+		t.Fatalf("slow client policy %q = %v, %t; want the server to use it", defs.SlowClientPolicyName, policy, ok)
+	}
+	policy, ok := SlowClientPolicyByName("Disconnect")
+	if !ok || policy != DisconnectSlowClient {
+		t.Fatalf("slow client policy \"Disconnect\" = %v, %t; want DisconnectSlowClient", policy, ok)
+	}
+	if _, ok := SlowClientPolicyByName("ignore"); ok {
+		t.Errorf("unknown slow client policy was found")
+	}
+
+	// connect a client that never reads
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Error upgrading test connection: %v", err)
+			return
+		}
+		conns <- conn
+	}))
+	defer srv.Close()
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Error dialing test server: %v", err)
+	}
+	defer client.Close()
+	sess := NewSession(<-conns, 2, policy)
+
+	// even a transient frame closes the session once the queue is full, since nothing is dropped to make room
+	sess.enqueue(outboundFrame{body: []byte("action1"), transient: true})
+	sess.enqueue(outboundFrame{body: []byte("host"), transient: false})
+	if sess.IsClosed() {
+		t.Fatalf("session was closed before its queue was full")
+	}
+	if sess.enqueue(outboundFrame{body: []byte("action2"), transient: true}) {
+		t.Errorf("frame was queued into a full queue")
+	}
+	if !sess.IsClosed() || sess.DroppedFrames() != 0 {
+		t.Errorf("session closed = %t with %d dropped frames; want it closed without dropping any", sess.IsClosed(), sess.DroppedFrames())
+	}
+}
+
+// check that client type names are matched to registered message types exactly, regardless of their namespace
+func TestMessageRegistryLookup(t *testing.T) {
+	cases := map[string]string{
+		"messages.PingMessage":            "ping",
+		"PaperVolleyball.Net.PingMessage": "ping",
+		"SwitchSideMessage":               "switchside",
+		"messages.SetBackdropMessage":     "setbackdrop",
+		"Client+BallStateMessage":         "ballstate",
+		"messages.PlayerActionMessage":    "playeraction",
+	}
+	for typeName, tag := range cases {
+		entry, ok := lookupMessageType(typeName)
+		if !ok || entry.tag != tag {
+			t.Errorf("lookup of %s = %v; want tag %s", typeName, entry, tag)
+		}
+	}
+	for _, typeName := range []string{"", "switch", "backdrop", "messages.PingPongMessage", "messages.ScoreMessage"} {
+		if _, ok := lookupMessageType(typeName); ok {
+			t.Errorf("lookup of %s matched a registered message type; want none", typeName)
+		}
+	}
+}
+
+// check that a message of an unknown type is answered with an error message
+func TestProcessUnknownMessageType(t *testing.T) {
+	s := NewServerData()
+	body, err := structures.ToWrappedJSON(messages.ScoreMessage{GameID: "xyzguid"})
+	if err != nil {
+		t.Fatalf("Error serializing: %v", err)
+	}
+	res, err := s.processws(&inbound{sess: &Session{ID: "test-session"}, codec: structures.JSONCodec, body: body})
+	if err == nil {
+		t.Errorf("processing an unknown message type returned no error")
+	}
+	errMsg, ok := res.(messages.ErrorMessage)
+	if !ok || errMsg.Code != messages.ErrCodeUnknownType || errMsg.RequestType != "messages.ScoreMessage" {
+		t.Errorf("response = %+v; want an UnknownType ErrorMessage for messages.ScoreMessage", res)
+	}
+}
+
+// check that a failed request is answered with the code of its error, and that its request id is kept for the response
+func TestProcessFailedRequest(t *testing.T) {
+	s := NewServerData()
+	for _, codec := range []structures.Codec{structures.JSONCodec, structures.BinaryCodec} {
+		body, err := codec.Encode(messages.AddPlayerLobbyMessage{ServerPlayerID: "nobody", RoomCode: "QBPX"}, "request-7")
+		if err != nil {
+			t.Fatalf("Error encoding with %s codec: %v", codec.Name(), err)
+		}
+		in := &inbound{sess: &Session{ID: "test-session"}, codec: codec, body: body}
+		res, err := s.processws(in)
+		if err == nil {
+			t.Errorf("joining a lobby as an unregistered player returned no error")
+		}
+		errMsg, ok := res.(messages.ErrorMessage)
+		if !ok || errMsg.Code != messages.ErrCodePlayerNotFound || errMsg.RequestType != "messages.AddPlayerLobbyMessage" {
+			t.Errorf("response = %+v; want a PlayerNotFound ErrorMessage for messages.AddPlayerLobbyMessage", res)
+		}
+		if in.requestID != "request-7" {
+			t.Errorf("request id = %q; want %q", in.requestID, "request-7")
+		}
+	}
+}
+
+// check that every registered message type is listed along with its fields
+func TestListMessageTypes(t *testing.T) {
+	list := ListMessageTypes()
+	if len(list) != len(messageRegistry) {
+		t.Fatalf("listed %d message types; want %d", len(list), len(messageRegistry))
+	}
+	for _, info := range list {
+		if info.Tag == "checklobby" && (len(info.Fields) != 3 || info.Fields[0].Name != "Exists" || info.Fields[0].Type != "bool" || info.Fields[2].Name != "RequiresPassword") {
+			t.Errorf("fields of checklobby = %+v; want Exists, RoomCode and RequiresPassword", info.Fields)
+		}
+	}
+}
+
+// check that player actions are relayed as they arrive, unless the game sends snapshots instead
+func TestPlayerActionsInSnapshotGames(t *testing.T) {
+	for _, snapshotHz := range []int{0, 20} {
+		s := NewServerData()
+		sess := &Session{
+			ID:        "test-session",
+			codec:     structures.JSONCodec,
+			queueSize: 8,
+			notify:    make(chan struct{}, 1),
+			closed:    make(chan struct{}),
+		}
+		s.Sessions.Store(sess.ID, sess)
+		player := states.NewPlayer(sess.ID)
+		player.Pos.X = 2 // spawn on the side of the court that the action moves within
+		s.Players.Store(player.GUID, player)
+		game := states.NewGameState()
+		game.SnapshotHz = snapshotHz
+		game.Players.Store(player.GUID, true)
+		s.Games.Store(game.GUID, game)
+
+		// send an action from the player
+		action := messages.PlayerActionMessage{PlayerServerID: player.GUID, GameID: game.GUID}
+		action.Action.Pos.X = 3
+		res, err := s.handleplayeraction(&inbound{sess: sess, codec: structures.JSONCodec}, action)
+		if err != nil {
+			t.Fatalf("handling a player action returned an error: %v", err)
+		}
+		if player.Pos.X != 3 {
+			t.Errorf("player position = %v; want the position of their action", player.Pos.X)
+		}
+
+		// check whether it was relayed
+		relayed := len(sess.queue) > 0
+		if relayed != (snapshotHz == 0) || (res == nil) == (snapshotHz == 0) {
+			t.Errorf("at %d Hz, action relayed = %t and echoed = %t; want both to be %t", snapshotHz, relayed, res != nil, snapshotHz == 0)
+		}
+
+		// check that snapshots carry the player's latest action
+		snapshot := s.collectPlayerActions(&game.RegisteredInstance)
+		if len(snapshot) != 1 || snapshot[player.GUID].Pos.X != 3 {
+			t.Errorf("snapshot players = %+v; want the player's latest action", snapshot)
+		}
+	}
+}
+
+// check that only the changed fields of players are sent against a baseline, and that players who left are listed
+func TestDiffPlayerActions(t *testing.T) {
+	baseline := playerActions{
+		"a": {Anim: "idle", AxisX: 0},
+		"b": {Anim: "run", AxisX: 1},
+		"c": {Anim: "idle"},
+	}
+	current := playerActions{
+		"a": {Anim: "idle", AxisX: -1},
+		"b": {Anim: "run", AxisX: 1},
+		"d": {Anim: "jump", FaceRight: true},
+	}
+	deltas, removed := diffPlayerActions(baseline, current)
+	if len(removed) != 1 || removed[0] != "c" {
+		t.Errorf("removed = %v; want [c]", removed)
+	}
+	if len(deltas) != 2 || deltas[0].ServerPlayerID != "a" || deltas[1].ServerPlayerID != "d" {
+		t.Fatalf("deltas = %+v; want changes for a and d", deltas)
+	}
+	if deltas[0].AxisX == nil || *deltas[0].AxisX != -1 || deltas[0].Pos != nil || deltas[0].Anim != nil || deltas[0].FaceRight != nil {
+		t.Errorf("delta of a = %+v; want only AxisX", deltas[0])
+	}
+
+	// applying the changes to the baseline gives back the current actions
+	for _, delta := range deltas {
+		action := baseline[delta.ServerPlayerID]
+		delta.Apply(&action)
+		if action != current[delta.ServerPlayerID] {
+			t.Errorf("applied delta of %s = %+v; want %+v", delta.ServerPlayerID, action, current[delta.ServerPlayerID])
+		}
+	}
+}
+
+// check that a session is sent full snapshots until it acknowledges one, and deltas against its latest acknowledged snapshot after
+func TestSnapshotBaseline(t *testing.T) {
+	sess := &Session{ID: "test-session"}
+	if _, _, ok := sess.snapshotBaseline("game"); ok {
+		t.Errorf("session has a baseline before any snapshots were sent")
+	}
+	for tick := uint64(1); tick <= 3; tick++ {
+		sess.recordSnapshot("game", tick, playerActions{"a": {AxisX: float32(tick)}})
+	}
+	if sess.ackSnapshot("game", 7) {
+		t.Errorf("acknowledged a snapshot that was never sent")
+	}
+	sess.ackSnapshot("game", 2)
+	sess.ackSnapshot("game", 1)
+	if tick, baseline, ok := sess.snapshotBaseline("game"); !ok || tick != 2 || baseline["a"].AxisX != 2 {
+		t.Errorf("baseline = %d %v %t; want the snapshot of tick 2", tick, baseline, ok)
+	}
+
+	// once the acknowledged snapshot is too old to be held, the session falls back to full snapshots
+	for tick := uint64(4); tick <= 3+defs.SnapshotHistorySize; tick++ {
+		sess.recordSnapshot("game", tick, playerActions{})
+	}
+	if _, _, ok := sess.snapshotBaseline("game"); ok {
+		t.Errorf("session kept a baseline that is no longer held")
+	}
+}
+
+// check that pings are stamped with the server's times, and that a player's round trip is measured from the echo of their previous reply
+func TestPingMeasuresRoundTrip(t *testing.T) {
+	s := NewServerData()
+	sess := &Session{ID: "test-session", codec: structures.JSONCodec}
+	player := states.NewPlayer(sess.ID)
+	s.Players.Store(player.GUID, player)
+
+	// the first ping has nothing to measure
+	received := time.Now()
+	res, err := s.handleping(&inbound{sess: sess, codec: structures.JSONCodec, received: received}, messages.PingMessage{PingTime: "abc", ServerPlayerID: player.GUID, ClientSendTime: 5})
+	if err != nil {
+		t.Fatalf("handling a ping returned an error: %v", err)
+	}
+	pong := res.(messages.PingMessage)
+	if pong.PingTime != "abc" || pong.ServerRecvTime != received.UnixMilli() || pong.ServerSendTime < pong.ServerRecvTime || pong.RTTMs != 0 {
+		t.Errorf("ping reply = %+v; want the ping time echoed and the server times stamped", pong)
+	}
+
+	// the next ping echoes the reply, received 40ms after it was sent and held for 100ms before pinging again
+	next := messages.PingMessage{
+		ServerPlayerID:     player.GUID,
+		PrevServerSendTime: pong.ServerSendTime,
+		PrevClientRecvTime: 1000,
+		ClientSendTime:     1100,
+	}
+	res, err = s.handleping(&inbound{sess: sess, codec: structures.JSONCodec, received: time.UnixMilli(pong.ServerSendTime + 180)}, next)
+	if err != nil {
+		t.Fatalf("handling a ping returned an error: %v", err)
+	}
+	pong = res.(messages.PingMessage)
+	if pong.RTTMs != 80 {
+		t.Errorf("measured round trip = %v ms; want 80", pong.RTTMs)
+	}
+
+	// once the player is on a new connection, echoes of replies sent on the old one are not measured
+	newIn := connectTestSession(s)
+	player.Reattach(newIn.sess.ID)
+	stale := messages.PingMessage{
+		ServerPlayerID:     player.GUID,
+		PrevServerSendTime: pong.ServerSendTime,
+		PrevClientRecvTime: 2000,
+		ClientSendTime:     2000,
+	}
+	newIn.received = time.UnixMilli(pong.ServerSendTime + 500)
+	res, err = s.handleping(newIn, stale)
+	if err != nil {
+		t.Fatalf("handling a ping returned an error: %v", err)
+	}
+	if pong := res.(messages.PingMessage); pong.RTTMs != 80 {
+		t.Errorf("round trip after echoing a reply from another connection = %v ms; want it left at 80", pong.RTTMs)
+	}
+
+	// pings can't be made on behalf of players on other connections
+	_, err = s.handleping(&inbound{sess: &Session{ID: "other-session"}, codec: structures.JSONCodec}, next)
+	if err == nil {
+		t.Errorf("pinging on behalf of a player on another connection returned no error")
+	}
+}
+
+// check that competing touches are won by whoever touched the ball first by their own perception, and that touches out of reach are denied
+func TestLagCompensatedTouches(t *testing.T) {
+	s := NewServerData()
+	game := states.NewGameState()
+	s.Games.Store(game.GUID, game)
+	ball := states.BallState{Pos: structures.Vector2{X: 5, Y: 3}, LiveState: states.BallLiveStateAlive}
+	ball.GUID = "live-ball"
+	game.UpdateBall(ball.Clone())
+
+	// add players near the ball (or not) with the given round trip times
+	addPlayer := func(posX float32, rttMs int64) *states.PlayerState {
+		player := states.NewPlayer("test-session")
+		player.UpdatePlayerState(&states.PlayerAction{Pos: structures.Vector2{X: posX, Y: 1}})
+		player.Latency.ReplySent(1000)
+		player.Latency.Measure(1000, 0, 0, 1000+rttMs)
+		s.Players.Store(player.GUID, player)
+		game.Players.Store(player.GUID, true)
+		return player
+	}
+	fast, slow, late, far := addPlayer(5, 20), addPlayer(6, 300), addPlayer(4, 0), addPlayer(-8, 0)
+
+	// send a touch by a player that was received at the given offset
+	start := time.Now()
+	touch := func(player *states.PlayerState, count int, offset time.Duration) error {
+		b := ball
+		b.TouchedBy = player.GUID
+		b.TouchCount = count
+		in := &inbound{sess: &Session{ID: "test-session"}, codec: structures.JSONCodec, received: start.Add(offset)}
+		_, err := s.handleballevent(in, messages.BallStateMessage{Ball: b, GameID: game.GUID})
+		return err
+	}
+
+	// the fast player's touch arrives first, but the slow player touched the ball earlier by their own perception
+	if err := touch(fast, 1, 0); err != nil {
+		t.Fatalf("first touch was denied: %v", err)
+	}
+	if err := touch(slow, 1, 50*time.Millisecond); err != nil {
+		t.Fatalf("touch perceived earlier was denied: %v", err)
+	}
+	if b := game.GetBallCopy(); b.TouchedBy != slow.GUID {
+		t.Errorf("ball touched by %s; want the touch perceived earlier to win", b.TouchedBy)
+	}
+
+	// a competing touch perceived later loses
+	if err := touch(late, 1, 60*time.Millisecond); err == nil {
+		t.Errorf("touch perceived later was accepted")
+	}
+
+	// a touch out of the player's reach is denied
+	if err := touch(far, 2, 70*time.Millisecond); err == nil {
+		t.Errorf("touch out of reach was accepted")
+	}
+	if b := game.GetBallCopy(); b.TouchedBy != slow.GUID || b.TouchCount != 1 {
+		t.Errorf("ball = %+v; want it unchanged by denied touches", b)
+	}
+}
+
+// check that a teleporting player is corrected instead of relayed, and counted for moderation
+func TestPlayerMovementCorrected(t *testing.T) {
+	s := NewServerData()
+	player := states.NewPlayer("test-session")
+	player.Pos.X = 2
+	s.Players.Store(player.GUID, player)
+	game := states.NewGameState()
+	game.Players.Store(player.GUID, true)
+	s.Games.Store(game.GUID, game)
+	in := &inbound{sess: &Session{ID: "test-session"}, codec: structures.JSONCodec}
+
+	// a small step is accepted
+	step := messages.PlayerActionMessage{PlayerServerID: player.GUID, GameID: game.GUID}
+	step.Action.Pos.X = 2.2
+	if _, err := s.handleplayeraction(in, step); err != nil || player.Pos.X != step.Action.Pos.X {
+		t.Fatalf("handling a step returned %v and left the player at %v; want it accepted", err, player.Pos.X)
+	}
+
+	// a teleport right after it is rejected, and the client is sent back to its last accepted position
+	teleport := messages.PlayerActionMessage{PlayerServerID: player.GUID, GameID: game.GUID}
+	teleport.Action.Pos.X = 11
+	res, err := s.handleplayeraction(in, teleport)
+	if err != nil {
+		t.Fatalf("handling a teleport returned an error: %v", err)
+	}
+	if force, ok := res.(messages.ForcePlayerMessage); !ok || force.Action.Pos.X != step.Action.Pos.X || player.Pos.X != step.Action.Pos.X {
+		t.Errorf("teleport reply = %+v with the player at %v; want a correction to the last position", res, player.Pos.X)
+	}
+
+	if counts, total, _ := player.Violations.Counts(); total != 1 || counts[states.ViolationTeleport] != 1 {
+		t.Errorf("violations = %+v; want the teleport counted", counts)
+	}
+}
+
+// check that players with invalid attributes are not admitted, and are told why
+func TestAdmissionValidatesAttributes(t *testing.T) {
+	s := NewServerData()
+	in := &inbound{sess: &Session{ID: "test-session", codec: structures.JSONCodec}, codec: structures.JSONCodec}
+	rq := messages.AdmissionMessage{ClientPlayerID: 3, Attributes: states.PlayerAttributes{DisplayName: "Ace", Strength: 9999}}
+	res, err := s.handleadmitplayer(in, rq)
+	if err != nil {
+		t.Fatalf("handling an admission returned an error: %v", err)
+	}
+	reply := res.(messages.AdmissionMessage)
+	if !strings.Contains(reply.ErrMsg, "Strength") || len(reply.ServerPlayerID) > 0 || reply.ClientPlayerID != 3 {
+		t.Errorf("admission reply = %+v; want the invalid stat explained and no player created", reply)
+	}
+
+	rq.Attributes.Strength = 4
+	res, _ = s.handleadmitplayer(in, rq)
+	if reply := res.(messages.AdmissionMessage); len(reply.ErrMsg) > 0 || len(reply.ServerPlayerID) == 0 {
+		t.Errorf("admission reply = %+v; want the player admitted", reply)
+	}
+}
+
+// check that display names with blocked words are handled according to the server's name policy
+func TestAdmissionFiltersNames(t *testing.T) {
+	s := NewServerData()
+	s.WordFilter = filter.NewWordList([]string{"darn"})
+	in := &inbound{sess: &Session{ID: "test-session", codec: structures.JSONCodec}, codec: structures.JSONCodec}
+	admit := func(name string) messages.AdmissionMessage {
+		res, err := s.handleadmitplayer(in, messages.AdmissionMessage{Attributes: states.PlayerAttributes{DisplayName: name}})
+		if err != nil {
+			t.Fatalf("handling an admission returned an error: %v", err)
+		}
+		return res.(messages.AdmissionMessage)
+	}
+	nameOf := func(reply messages.AdmissionMessage) string {
+		player, err := s.FindPlayer(reply.ServerPlayerID)
+		if err != nil {
+			t.Fatalf("admitted player %q not found: %v", reply.ServerPlayerID, err)
+		}
+		return player.DisplayName
+	}
+
+	s.NamePolicy = filter.PolicyReject
+	if reply := admit("D4rn Spiker"); len(reply.ErrMsg) == 0 || len(reply.ServerPlayerID) > 0 {
+		t.Errorf("admission reply = %+v; want the name rejected", reply)
+	}
+	s.NamePolicy = filter.PolicyMask
+	if name := nameOf(admit("D4rn Spiker")); name != "**** Spiker" {
+		t.Errorf("display name = %q; want the blocked word masked", name)
+	}
+	s.NamePolicy = filter.PolicyReplace
+	if name := nameOf(admit("D4rn Spiker")); !strings.HasPrefix(name, "Guest") {
+		t.Errorf("display name = %q; want a guest name", name)
+	}
+	if name := nameOf(admit("Spiker")); name != "Spiker" {
+		t.Errorf("display name = %q; want a clean name kept", name)
+	}
+}
+
+// check that chat messages reach only those who may see them, are rate limited, and are replayed to late joiners
+func TestLobbyChat(t *testing.T) {
+	s := NewServerData()
+	lobby := newTestLobby(s)
+
+	// connect a player on their own connection, and add them to the lobby at a position on the court (or spectating)
+	addPlayer := func(posX float32, spectate bool) (*states.PlayerState, *Session) {
+		player, in := connectTestPlayer(s)
+		player.Pos.X = posX
+		player.IsSpectator = spectate
+		if spectate {
+			lobby.Spectators.Store(player.GUID, true)
+		} else {
+			lobby.Players.Store(player.GUID, true)
+		}
+		return player, in.sess
+	}
+	chats := func(sess *Session) int {
+		n := 0
+		for _, frame := range sess.queue {
+			if strings.Contains(string(frame.body), "messages.ChatMessage") {
+				n++
+			}
+		}
+		sess.queue = nil
+		return n
+	}
+	say := func(player *states.PlayerState, sess *Session, teamOnly bool) error {
+		_, err := s.handlechat(&inbound{sess: sess, codec: structures.JSONCodec}, messages.ChatMessage{ServerPlayerID: player.GUID, RoomCode: lobby.RoomCode, Text: "hello", TeamOnly: teamOnly})
+		return err
+	}
+	alice, aliceSess := addPlayer(-3, false)
+	_, bobSess := addPlayer(-5, false)
+	carol, carolSess := addPlayer(4, false)
+	_, specSess := addPlayer(0, true)
+	carol.SetMuted(alice.GUID, true)
+
+	// public messages reach everyone but those who muted the sender, and team messages only reach the team
+	if err := say(alice, aliceSess, false); err != nil {
+		t.Fatalf("sending a chat message returned an error: %v", err)
+	}
+	if got := []int{chats(aliceSess), chats(bobSess), chats(carolSess), chats(specSess)}; !slices.Equal(got, []int{1, 1, 0, 1}) {
+		t.Errorf("public chat deliveries = %v; want everyone but the player who muted the sender", got)
+	}
+	if err := say(alice, aliceSess, true); err != nil {
+		t.Fatalf("sending a team chat message returned an error: %v", err)
+	}
+	if got := []int{chats(aliceSess), chats(bobSess), chats(carolSess), chats(specSess)}; !slices.Equal(got, []int{1, 1, 0, 0}) {
+		t.Errorf("team chat deliveries = %v; want only the sender's team", got)
+	}
+
+	// messages can't be sent on behalf of other players, and are limited once the burst is used up
+	if err := say(alice, bobSess, false); err == nil {
+		t.Errorf("chatting on behalf of a player on another connection returned no error")
+	}
+	for i := 2; i < defs.ChatBurst; i++ {
+		say(alice, aliceSess, false)
+	}
+	if err := say(alice, aliceSess, false); err == nil || !strings.Contains(newErrorMessage(err, "").Code, messages.ErrCodeRateLimited) {
+		t.Errorf("chatting past the burst returned %v; want a rate limit error", err)
+	}
+
+	// a spectator joining later is sent the public messages so far
+	late, lateIn := connectTestPlayer(s)
+	if _, err := s.handleaddplayerlobby(lateIn, messages.AddPlayerLobbyMessage{ServerPlayerID: late.GUID, RoomCode: lobby.RoomCode, Spectate: true}); err != nil {
+		t.Fatalf("joining the lobby returned an error: %v", err)
+	}
+	if n := chats(lateIn.sess); n != defs.ChatBurst-1 {
+		t.Errorf("late joiner was sent %d chat messages; want the %d public ones", n, defs.ChatBurst-1)
+	}
+}
+
+// check that emotes are checked against the catalog and cooldown before they are passed on
+func TestEmotes(t *testing.T) {
+	s := NewServerData()
+	s.Emotes = emotes.NewCatalog([]emotes.Emote{{Code: "nice", Text: "nice!"}})
+	player, in := connectTestPlayer(s)
+	sess := in.sess
+	game := states.NewGameState()
+	game.Players.Store(player.GUID, true)
+	s.Games.Store(game.GUID, game)
+	emote := func(code string) error {
+		_, err := s.handleemote(in, messages.EmoteMessage{ServerPlayerID: player.GUID, GameID: game.GUID, Code: code})
+		return err
+	}
+
+	if err := emote("taunt"); err == nil {
+		t.Errorf("firing an emote that is not in the catalog returned no error")
+	}
+	if err := emote("nice"); err != nil {
+		t.Fatalf("firing an emote returned an error: %v", err)
+	}
+	if len(sess.queue) != 1 || !strings.Contains(string(sess.queue[0].body), "nice!") {
+		t.Errorf("queued frames = %d; want the emote broadcast with its text", len(sess.queue))
+	}
+	if err := emote("nice"); err == nil {
+		t.Errorf("firing an emote during the cooldown returned no error")
+	}
+}
+
+// check that only the host can kick, ban and lock, and that bans and locks keep players out of the lobby
+func TestLobbyModeration(t *testing.T) {
+	s := NewServerData()
+	lobby := newTestLobby(s)
+	join := func(player *states.PlayerState, in *inbound) error {
+		_, err := s.handleaddplayerlobby(in, messages.AddPlayerLobbyMessage{ServerPlayerID: player.GUID, RoomCode: lobby.RoomCode})
+		return err
+	}
+	host, hostIn := connectTestPlayer(s)
+	guest, guestIn := connectTestPlayer(s)
+	joinTestLobby(t, s, lobby, host, hostIn)
+	joinTestLobby(t, s, lobby, guest, guestIn)
+	if lobby.HostID != host.GUID {
+		t.Fatalf("host = %s; want the first player to join", lobby.HostID)
+	}
+
+	// players other than the host have no powers
+	_, err := s.handlekickplayer(guestIn, messages.KickPlayerMessage{ServerPlayerID: guest.GUID, TargetPlayerID: host.GUID, RoomCode: lobby.RoomCode})
+	if errorCode(err) != messages.ErrCodeNotHost {
+		t.Errorf("kick by a non-host returned %v; want a not host error", err)
+	}
+	_, err = s.handlelocklobby(guestIn, messages.LockLobbyMessage{ServerPlayerID: guest.GUID, RoomCode: lobby.RoomCode, Locked: true})
+	if errorCode(err) != messages.ErrCodeNotHost || lobby.IsLocked() {
+		t.Errorf("lock by a non-host returned %v; want a not host error", err)
+	}
+
+	// a banned player is told why they were removed, and can't come back from the same connection
+	drainQueue(guestIn.sess)
+	if _, err := s.handlekickplayer(hostIn, messages.KickPlayerMessage{ServerPlayerID: host.GUID, TargetPlayerID: guest.GUID, RoomCode: lobby.RoomCode, Ban: true}); err != nil {
+		t.Fatalf("kick by the host returned an error: %v", err)
+	}
+	if _, ok := lobby.Players.Load(guest.GUID); ok {
+		t.Errorf("kicked player is still in the lobby")
+	}
+	if len(guestIn.sess.queue) != 1 || !strings.Contains(string(guestIn.sess.queue[0].body), "Banned by the host") {
+		t.Errorf("kicked player was sent %d frames; want the leave message with its reason", len(guestIn.sess.queue))
+	}
+	rejoined := addTestPlayer(s, guestIn)
+	if err := join(rejoined, guestIn); errorCode(err) != messages.ErrCodeBanned {
+		t.Errorf("banned player rejoining returned %v; want a banned error", err)
+	}
+
+	// a locked lobby turns away newcomers until it is unlocked
+	lock := func(locked bool) {
+		if _, err := s.handlelocklobby(hostIn, messages.LockLobbyMessage{ServerPlayerID: host.GUID, RoomCode: lobby.RoomCode, Locked: locked}); err != nil {
+			t.Fatalf("lock by the host returned an error: %v", err)
+		}
+	}
+	newcomer, newcomerIn := connectTestPlayer(s)
+	lock(true)
+	if err := join(newcomer, newcomerIn); errorCode(err) != messages.ErrCodeLobbyLocked {
+		t.Errorf("joining a locked lobby returned %v; want a locked error", err)
+	}
+	if len(newcomer.RoomCode) > 0 {
+		t.Errorf("player turned away from a locked lobby still has room code %s", newcomer.RoomCode)
+	}
+	lock(false)
+	if err := join(newcomer, newcomerIn); err != nil {
+		t.Errorf("joining an unlocked lobby returned an error: %v", err)
+	}
+}
+
+// check that the host can hand off host status, and that a new host is elected by join order or ping when the host leaves
+func TestHostTransferAndElection(t *testing.T) {
+	s := NewServerData()
+	lobby := newTestLobby(s)
+	join := func(rttMs int64) (*states.PlayerState, *Session) {
+		player, in := connectTestPlayer(s)
+		if rttMs > 0 {
+			player.Latency.ReplySent(1000)
+			player.Latency.Measure(1000, 5000, 5000, 1000+rttMs)
+		}
+		joinTestLobby(t, s, lobby, player, in)
+		return player, in.sess
+	}
+	transfer := func(from *states.PlayerState, sess *Session, to *states.PlayerState) error {
+		_, err := s.handletransferhost(&inbound{sess: sess, codec: structures.JSONCodec}, messages.TransferHostMessage{ServerPlayerID: from.GUID, TargetPlayerID: to.GUID, RoomCode: lobby.RoomCode})
+		return err
+	}
+	first, firstSess := join(0)
+	second, secondSess := join(80)
+	third, _ := join(20)
+	fourth, _ := join(0)
+
+	// only the host may hand off host status, and everyone is told of the change
+	if err := transfer(second, secondSess, third); err == nil {
+		t.Errorf("transfer by a non-host returned no error")
+	}
+	secondSess.queue = nil
+	if err := transfer(first, firstSess, fourth); err != nil {
+		t.Fatalf("transfer by the host returned an error: %v", err)
+	}
+	if lobby.HostID != fourth.GUID {
+		t.Errorf("host = %s; want the player it was handed to", lobby.HostID)
+	}
+	if len(secondSess.queue) != 1 || !strings.Contains(string(secondSess.queue[0].body), "messages.SyncHostMessage") {
+		t.Errorf("other player was sent %d frames; want the new host", len(secondSess.queue))
+	}
+
+	// by default, the longest present player takes over
+	s.removePlayerLobby(fourth.GUID, lobby.RoomCode, "")
+	if lobby.HostID != first.GUID {
+		t.Errorf("host after leaving = %s; want the longest present player", lobby.HostID)
+	}
+
+	// or the player with the lowest measured ping
+	s.HostElection = ElectLowestPing
+	s.removePlayerLobby(first.GUID, lobby.RoomCode, "")
+	if lobby.HostID != third.GUID {
+		t.Errorf("host after leaving = %s; want the player with the lowest ping", lobby.HostID)
+	}
+}
+
+// check that private lobbies let players in only with the password or an unused invite, and that guessing is throttled
+func TestPrivateLobbies(t *testing.T) {
+	s := NewServerData()
+	lobby := newTestLobby(s)
+	join := func(player *states.PlayerState, in *inbound, password string, invite string) (any, error) {
+		return s.handleaddplayerlobby(in, messages.AddPlayerLobbyMessage{ServerPlayerID: player.GUID, RoomCode: lobby.RoomCode, Password: password, InviteToken: invite})
+	}
+	host, hostIn := connectTestPlayer(s)
+	joinTestLobby(t, s, lobby, host, hostIn)
+
+	// only the host may set the password, and it is not echoed back
+	guest, guestIn := connectTestPlayer(s)
+	if _, err := s.handlesetlobbypassword(guestIn, messages.SetLobbyPasswordMessage{ServerPlayerID: guest.GUID, RoomCode: lobby.RoomCode, Password: "x"}); errorCode(err) != messages.ErrCodeNotHost {
+		t.Errorf("setting the password as a non-host returned %v; want a not host error", err)
+	}
+	resp, err := s.handlesetlobbypassword(hostIn, messages.SetLobbyPasswordMessage{ServerPlayerID: host.GUID, RoomCode: lobby.RoomCode, Password: "spike"})
+	if err != nil {
+		t.Fatalf("setting the password returned an error: %v", err)
+	}
+	if reply := resp.(messages.SetLobbyPasswordMessage); !reply.RequiresPassword || len(reply.Password) > 0 {
+		t.Errorf("reply to setting the password = %+v; want it required and left out", reply)
+	}
+
+	// checking the room code tells that it is private
+	resp, err = s.handlechecklobby(guestIn, messages.CheckLobbyMessage{RoomCode: lobby.RoomCode})
+	if check := resp.(messages.CheckLobbyMessage); err != nil || !check.Exists || !check.RequiresPassword {
+		t.Errorf("checking a private lobby = %+v, %v; want it to exist and require a password", check, err)
+	}
+
+	// the password lets a player in, and is not echoed back
+	if _, err := join(guest, guestIn, "", ""); errorCode(err) != messages.ErrCodeBadPassword {
+		t.Errorf("joining without the password returned %v; want a bad password error", err)
+	}
+	resp, err = join(guest, guestIn, "spike", "")
+	if err != nil {
+		t.Fatalf("joining with the password returned an error: %v", err)
+	}
+	if reply := resp.(messages.AddPlayerLobbyMessage); len(reply.Password) > 0 {
+		t.Errorf("reply to joining echoed the password")
+	}
+
+	// an invite lets one player in
+	resp, err = s.handlecreateinvite(hostIn, messages.CreateInviteMessage{ServerPlayerID: host.GUID, RoomCode: lobby.RoomCode})
+	if err != nil {
+		t.Fatalf("creating an invite returned an error: %v", err)
+	}
+	invite := resp.(messages.CreateInviteMessage).InviteToken
+	invited, invitedIn := connectTestPlayer(s)
+	if _, err := join(invited, invitedIn, "", invite); err != nil {
+		t.Errorf("joining with an invite returned an error: %v", err)
+	}
+	other, otherIn := connectTestPlayer(s)
+	if _, err := join(other, otherIn, "", invite); errorCode(err) != messages.ErrCodeBadPassword {
+		t.Errorf("joining with a used invite returned %v; want a bad password error", err)
+	}
+
+	// a connection that keeps failing is refused even with the right password
+	guesser, guesserIn := connectTestPlayer(s)
+	for i := 0; i < defs.LobbyAttemptBurst; i++ {
+		join(guesser, guesserIn, "guess", "")
+	}
+	if _, err := join(guesser, guesserIn, "spike", ""); errorCode(err) != messages.ErrCodeRateLimited {
+		t.Errorf("joining after too many failed attempts returned %v; want a rate limit error", err)
+	}
+	if _, err := s.handlechecklobby(guesserIn, messages.CheckLobbyMessage{RoomCode: "ZZZZ"}); errorCode(err) != messages.ErrCodeRateLimited {
+		t.Errorf("checking a room code after too many failed attempts returned %v; want a rate limit error", err)
+	}
+
+	// passwords are kept out of the logs
+	body, _ := structures.JSONCodec.Encode(messages.AddPlayerLobbyMessage{RoomCode: lobby.RoomCode, Password: "spike"}, "")
+	if desc := describews(structures.JSONCodec, body); strings.Contains(desc, "spike") {
+		t.Errorf("logged description of a join request = %s; want the password left out", desc)
+	}
+}
+
+// check that only public lobbies are listed, and that the listing can be filtered and paged over websockets and http
+func TestListPublicLobbies(t *testing.T) {
+	s := NewServerData()
+	in := connectTestSession(s)
+	create := func(public bool, numPlayers int) *states.LobbyState {
+		resp, err := s.handlecreatelobby(in, messages.CreateLobbyMessage{IsPublic: public})
+		if err != nil {
+			t.Fatalf("creating a lobby returned an error: %v", err)
+		}
+		created := resp.(messages.CreateLobbyMessage)
+		if created.IsPublic != public {
+			t.Errorf("created lobby public = %t; want %t", created.IsPublic, public)
+		}
+		lobby, _ := s.FindLobby(created.RoomCode)
+		for i := 0; i < numPlayers; i++ {
+			player := states.NewPlayer("")
+			player.DisplayName = fmt.Sprintf("Player%d", i)
+			s.Players.Store(player.GUID, player)
+			lobby.Players.Store(player.GUID, true)
+			if i == 0 {
+				lobby.HostID = player.GUID
+			}
+		}
+		return lobby
+	}
+	list := func(rq messages.ListLobbiesMessage) messages.ListLobbiesMessage {
+		resp, err := s.handlelistlobbies(in, rq)
+		if err != nil {
+			t.Fatalf("listing lobbies returned an error: %v", err)
+		}
+		return resp.(messages.ListLobbiesMessage)
+	}
+	roomCodes := func(reply messages.ListLobbiesMessage) []string {
+		codes := []string{}
+		for _, listing := range reply.Lobbies {
+			codes = append(codes, listing.RoomCode)
+		}
+		return codes
+	}
+	busy := create(true, 3)
+	quiet := create(true, 1)
+	private := create(true, 2)
+	private.SetPassword("spike")
+	create(false, 4)
+	create(true, 5).SetLocked(true)
+
+	// unlisted and locked lobbies are left out, and the rest come with the most players first
+	all := list(messages.ListLobbiesMessage{})
+	if want := []string{busy.RoomCode, private.RoomCode, quiet.RoomCode}; !slices.Equal(roomCodes(all), want) || all.Total != 3 {
+		t.Fatalf("listed lobbies = %v of %d; want %v", roomCodes(all), all.Total, want)
+	}
+	if listing := all.Lobbies[0]; listing.NumPlayers != 3 || listing.HostDisplayName != "Player0" || listing.MaxPlayers != 2*defs.MaxTeamPlayers {
+		t.Errorf("listing = %+v; want the lobby's player count and host", listing)
+	}
+	if !all.Lobbies[1].RequiresPassword {
+		t.Errorf("private lobby is not listed as requiring a password")
+	}
+
+	// filters and pages
+	if got := roomCodes(list(messages.ListLobbiesMessage{HidePrivate: true})); !slices.Equal(got, []string{busy.RoomCode, quiet.RoomCode}) {
+		t.Errorf("lobbies without a password = %v", got)
+	}
+	if page := list(messages.ListLobbiesMessage{Page: 1, PageSize: 2}); !slices.Equal(roomCodes(page), []string{quiet.RoomCode}) || page.Total != 3 {
+		t.Errorf("second page of 2 = %v of %d; want the last lobby of 3", roomCodes(page), page.Total)
+	}
+	if page := list(messages.ListLobbiesMessage{Page: 5}); len(page.Lobbies) != 0 || page.PageSize != defs.LobbyBrowserPageSize {
+		t.Errorf("page past the end = %+v; want no lobbies at the default page size", page)
+	}
+	if page := list(messages.ListLobbiesMessage{Page: math.MaxInt / 2, PageSize: 20}); len(page.Lobbies) != 0 || page.Total != 3 {
+		t.Errorf("page far past the end = %+v; want no lobbies", page)
+	}
+
+	// the same listing over http
+	w := httptest.NewRecorder()
+	s.HandleLobbies(w, httptest.NewRequest(http.MethodGet, "/lobbies?pageSize=1&hidePrivate=true", nil))
+	var reply messages.ListLobbiesMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil {
+		t.Fatalf("unable to decode lobby listing: %v", err)
+	}
+	if !slices.Equal(roomCodes(reply), []string{busy.RoomCode}) || reply.Total != 2 {
+		t.Errorf("http listing = %v of %d; want the busiest lobby of 2", roomCodes(reply), reply.Total)
+	}
+	w = httptest.NewRecorder()
+	s.HandleLobbies(w, httptest.NewRequest(http.MethodGet, "/lobbies?page=first", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("http listing with a bad page returned status %d; want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+// check that the ball can only be touched by a player of the game on the connection that sent the touch
+func TestBallTouchesBoundToSession(t *testing.T) {
+	s := NewServerData()
+	game := states.NewGameState()
+	s.Games.Store(game.GUID, game)
+	ball := states.BallState{Pos: structures.Vector2{X: 5, Y: 3}, LiveState: states.BallLiveStateAlive}
+	ball.GUID = "live-ball"
+	game.UpdateBall(ball.Clone())
+	addPlayer := func(sessID string, spectate bool) *states.PlayerState {
+		player := states.NewPlayer(sessID)
+		player.UpdatePlayerState(&states.PlayerAction{Pos: structures.Vector2{X: 5, Y: 1}})
+		s.Players.Store(player.GUID, player)
+		if spectate {
+			game.Spectators.Store(player.GUID, true)
+		} else {
+			game.Players.Store(player.GUID, true)
+		}
+		return player
+	}
+	player := addPlayer("player-session", false)
+	spectator := addPlayer("spectator-session", true)
+	outsider := addPlayer("outsider-session", false)
+	game.Players.Delete(outsider.GUID)
+	touch := func(sessID string, touchedBy string) error {
+		b := ball
+		b.TouchedBy = touchedBy
+		b.TouchCount = 1
+		_, err := s.handleballevent(&inbound{sess: &Session{ID: sessID}, codec: structures.JSONCodec}, messages.BallStateMessage{Ball: b, GameID: game.GUID})
+		return err
+	}
+
+	for _, tc := range []struct {
+		name      string
+		sessID    string
+		touchedBy string
+	}{
+		{"unknown player", "player-session", "nobody"},
+		{"no player", "player-session", ""},
+		{"player on another connection", "spectator-session", player.GUID},
+		{"spectator", "spectator-session", spectator.GUID},
+		{"player outside the game", "outsider-session", outsider.GUID},
+	} {
+		if err := touch(tc.sessID, tc.touchedBy); err == nil {
+			t.Errorf("touch by %s was accepted", tc.name)
+		}
+	}
+	if b := game.GetBallCopy(); len(b.TouchedBy) > 0 || b.TouchCount != 0 {
+		t.Errorf("ball = %+v; want it unchanged by denied touches", b)
+	}
+	if err := touch("player-session", player.GUID); err != nil {
+		t.Errorf("touch by a player of the game on their own connection was denied: %v", err)
+	}
+}
+
+// check that a player joining a game directly is put on a side of the court, so that their movement on the right side is not taken for crossing the net
+func TestGameJoinSpawnsOnSide(t *testing.T) {
+	s := NewServerData()
+	game := states.NewGameState()
+	s.Games.Store(game.GUID, game)
+	join := func() (*states.PlayerState, *inbound) {
+		player, in := connectTestPlayer(s)
+		if _, err := s.handleaddplayergame(in, messages.AddPlayerGameMessage{ServerPlayerID: player.GUID, GameID: game.GUID}); err != nil {
+			t.Fatalf("joining the game returned an error: %v", err)
+		}
+		return player, in
+	}
+	left, _ := join()
+	right, in := join()
+	if states.TeamAtPosX(left.Pos.X) != states.TeamLeft || states.TeamAtPosX(right.Pos.X) != states.TeamRight {
+		t.Fatalf("players spawned at %v and %v; want one on each side", left.Pos.X, right.Pos.X)
+	}
+	if len(in.sess.queue) == 0 || !strings.Contains(string(in.sess.queue[0].body), "messages.ForcePlayerMessage") {
+		t.Errorf("joining player was not sent their spawn position")
+	}
+
+	// moving about on the right side is not a violation
+	step := messages.PlayerActionMessage{PlayerServerID: right.GUID, GameID: game.GUID}
+	step.Action.Pos.X = right.Pos.X + 0.1
+	if _, err := s.handleplayeraction(in, step); err != nil || right.Pos.X != step.Action.Pos.X {
+		t.Errorf("handling a step on the right side returned %v and left the player at %v; want it accepted", err, right.Pos.X)
+	}
+	if _, total, _ := right.Violations.Counts(); total != 0 {
+		t.Errorf("right side player has %d movement violations; want none", total)
+	}
+}
+
+// connect a player to the server on their own connection, for tests that need requests to come from the player's connection
+func connectTestPlayer(s *ServerData) (*states.PlayerState, *inbound) {
+	in := connectTestSession(s)
+	return addTestPlayer(s, in), in
+}
+
+// add a new player on an existing connection
+func addTestPlayer(s *ServerData, in *inbound) *states.PlayerState {
+	player := states.NewPlayer(in.sess.ID)
+	s.Players.Store(player.GUID, player)
+	return player
+}
+
+// open a session that queues the messages sent to it, without a player attached
+func connectTestSession(s *ServerData) *inbound {
+	sess := &Session{ID: uuid.New().String(), codec: structures.JSONCodec, queueSize: 64, notify: make(chan struct{}, 1), closed: make(chan struct{})}
+	s.Sessions.Store(sess.ID, sess)
+	return &inbound{sess: sess, codec: structures.JSONCodec}
+}
+
+// create an empty lobby on the server
+func newTestLobby(s *ServerData) *states.LobbyState {
+	lobby := states.NewLobbyState(&s.Lobbies)
+	s.Lobbies.Store(lobby.RoomCode, lobby)
+	return lobby
+}
+
+// add a player to a lobby from their connection, failing the test if they can't join
+func joinTestLobby(t *testing.T, s *ServerData, lobby *states.LobbyState, player *states.PlayerState, in *inbound) {
+	t.Helper()
+	if _, err := s.handleaddplayerlobby(in, messages.AddPlayerLobbyMessage{ServerPlayerID: player.GUID, RoomCode: lobby.RoomCode}); err != nil {
+		t.Fatalf("joining the lobby returned an error: %v", err)
+	}
+}
+
+// add a player to a game from their connection, failing the test if they can't join
+func joinTestGame(t *testing.T, s *ServerData, game *states.GameState, player *states.PlayerState, in *inbound) {
+	t.Helper()
+	if _, err := s.handleaddplayergame(in, messages.AddPlayerGameMessage{ServerPlayerID: player.GUID, GameID: game.GUID}); err != nil {
+		t.Fatalf("joining the game returned an error: %v", err)
+	}
+}
+
+// returns the code that an error would be reported to the client with, or an empty string if there is no error
+func errorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	return newErrorMessage(err, "").Code
+}
+
+// returns whether a message of the given type was queued on a session, and empties its queue
+func drainForType(sess *Session, typeName string) bool {
+	return strings.Contains(drainQueue(sess), typeName)
+}
+
+// returns the frames queued on a session, one per line, and empties its queue
+func drainQueue(sess *Session) string {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	bodies := []string{}
+	for _, frame := range sess.queue {
+		bodies = append(bodies, string(frame.body))
+	}
+	sess.queue = nil
+	return strings.Join(bodies, "\n")
+}
+
+// check that a lobby's match moves everyone into a game, and that only the game's host can bring them back
+func TestStartAndReturnToLobby(t *testing.T) {
+	s := NewServerData()
+	lobby := newTestLobby(s)
+	host, hostIn := connectTestPlayer(s)
+	guest, guestIn := connectTestPlayer(s)
+	joinTestLobby(t, s, lobby, host, hostIn)
+	joinTestLobby(t, s, lobby, guest, guestIn)
+	drainForType(guestIn.sess, "")
+
+	// starting the match moves everyone into a game with the lobby's host
+	game := s.startLobbyMatch(lobby, states.GameRules{})
+	if !s.isLobbyInMatch(lobby) || host.GameID != game.GUID || guest.GameID != game.GUID || game.HostID != host.GUID {
+		t.Fatalf("after starting the match, lobby game = %s, player games = %s and %s, game host = %s; want everyone in game %s", lobby.GameID, host.GameID, guest.GameID, game.HostID, game.GUID)
+	}
+	if !drainForType(guestIn.sess, "messages.StartMatchMessage") {
+		t.Errorf("guest was not told that the match started")
+	}
+
+	// the guest can't end the match, even by giving the host's id
+	for _, id := range []string{guest.GUID, host.GUID} {
+		if _, err := s.handlereturnlobby(guestIn, messages.ReturnLobbyMessage{ServerPlayerID: id, GameID: game.GUID}); err == nil {
+			t.Errorf("returning to the lobby as %s from the guest's connection returned no error", id)
+		}
+	}
+	if !s.isLobbyInMatch(lobby) {
+		t.Fatalf("lobby left its match after requests that were refused")
+	}
+
+	// the host brings everyone back
+	if _, err := s.handlereturnlobby(hostIn, messages.ReturnLobbyMessage{ServerPlayerID: host.GUID, GameID: game.GUID}); err != nil {
+		t.Fatalf("returning to the lobby as the host returned an error: %v", err)
+	}
+	if s.isLobbyInMatch(lobby) || len(host.GameID) > 0 || len(guest.GameID) > 0 {
+		t.Errorf("after returning to the lobby, lobby game = %s and player games = %s and %s; want none", lobby.GameID, host.GameID, guest.GameID)
+	}
+	if _, err := s.FindGame(game.GUID); err == nil {
+		t.Errorf("game still exists after returning to the lobby")
+	}
+	if !drainForType(guestIn.sess, "messages.ReturnLobbyMessage") {
+		t.Errorf("guest was not told that they are back in the lobby")
+	}
+}
+
+// check that players can only ready up from their own connection, and that the countdown starts and stops with the ready check
+func TestReadyCheckCountdown(t *testing.T) {
+	s := NewServerData()
+	lobby := newTestLobby(s)
+	host, hostIn := connectTestPlayer(s)
+	guest, guestIn := connectTestPlayer(s)
+	joinTestLobby(t, s, lobby, host, hostIn)
+	joinTestLobby(t, s, lobby, guest, guestIn)
+	drainForType(guestIn.sess, "")
+
+	// the host can't ready up on behalf of the guest
+	if _, err := s.handleready(hostIn, messages.ReadyMessage{ServerPlayerID: guest.GUID, RoomCode: lobby.RoomCode, IsReady: true}); err == nil {
+		t.Errorf("readying the guest from the host's connection returned no error")
+	}
+	if _, ok := lobby.Ready.Load(guest.GUID); ok {
+		t.Fatalf("guest was marked as ready by a request from the host's connection")
+	}
+
+	// one ready player out of two updates the roster without starting the countdown
+	if _, err := s.handleready(hostIn, messages.ReadyMessage{ServerPlayerID: host.GUID, RoomCode: lobby.RoomCode, IsReady: true}); err != nil {
+		t.Fatalf("readying the host returned an error: %v", err)
+	}
+	if !drainForType(guestIn.sess, "messages.ReadyRosterMessage") {
+		t.Errorf("guest was not sent the ready roster")
+	}
+
+	// once everyone is ready the countdown starts, and it is cancelled when someone backs out
+	if _, err := s.handleready(guestIn, messages.ReadyMessage{ServerPlayerID: guest.GUID, RoomCode: lobby.RoomCode, IsReady: true}); err != nil {
+		t.Fatalf("readying the guest returned an error: %v", err)
+	}
+	if _, ok := lobby.StartCountdown(); ok {
+		t.Fatalf("countdown was not running after everyone became ready")
+	}
+	drainForType(guestIn.sess, "")
+	if _, err := s.handleready(guestIn, messages.ReadyMessage{ServerPlayerID: guest.GUID, RoomCode: lobby.RoomCode, IsReady: false}); err != nil {
+		t.Fatalf("unreadying the guest returned an error: %v", err)
+	}
+	if !drainForType(guestIn.sess, `\"Cancelled\":true`) {
+		t.Errorf("guest was not told that the countdown was cancelled")
+	}
+	if s.isLobbyInMatch(lobby) {
+		t.Errorf("lobby started a match after the countdown was cancelled")
+	}
+}
+
+// check that joining as a player stops someone from spectating, and that only the host's connection can promote spectators
+func TestSpectatorJoinAndPromote(t *testing.T) {
+	s := NewServerData()
+	lobby := newTestLobby(s)
+	host, hostIn := connectTestPlayer(s)
+	watcher, watcherIn := connectTestPlayer(s)
+	join := func(player *states.PlayerState, in *inbound, spectate bool) {
+		if _, err := s.handleaddplayerlobby(in, messages.AddPlayerLobbyMessage{ServerPlayerID: player.GUID, RoomCode: lobby.RoomCode, Spectate: spectate}); err != nil {
+			t.Fatalf("joining the lobby with spectate = %v returned an error: %v", spectate, err)
+		}
+	}
+	isSpectating := func(r *states.RegisteredInstance, player *states.PlayerState) bool {
+		isPlayer, isSpectator := instanceRole(r, player.GUID)
+		if isPlayer == isSpectator {
+			t.Fatalf("player %s has isPlayer = %v and isSpectator = %v; want exactly one", player.GUID, isPlayer, isSpectator)
+		}
+		if player.IsSpectator != isSpectator {
+			t.Fatalf("player %s has IsSpectator = %v but isSpectator = %v in the instance", player.GUID, player.IsSpectator, isSpectator)
+		}
+		return isSpectator
+	}
+	join(host, hostIn, false)
+
+	// spectating and then joining as a player moves them from one list to the other
+	join(watcher, watcherIn, true)
+	if !isSpectating(&lobby.RegisteredInstance, watcher) {
+		t.Errorf("watcher is not spectating after joining as a spectator")
+	}
+	join(watcher, watcherIn, false)
+	if isSpectating(&lobby.RegisteredInstance, watcher) {
+		t.Errorf("watcher is still spectating after joining as a player")
+	}
+	join(watcher, watcherIn, true)
+	if !isSpectating(&lobby.RegisteredInstance, watcher) {
+		t.Errorf("watcher is not spectating after joining as a spectator again")
+	}
+
+	// the watcher can't promote themselves, even by giving the host's id
+	for _, id := range []string{watcher.GUID, host.GUID} {
+		if _, err := s.handlepromotespectator(watcherIn, messages.PromoteSpectatorMessage{ServerPlayerID: id, TargetPlayerID: watcher.GUID, RoomCode: lobby.RoomCode}); err == nil {
+			t.Errorf("promoting the watcher as %s from the watcher's connection returned no error", id)
+		}
+	}
+	if !isSpectating(&lobby.RegisteredInstance, watcher) {
+		t.Fatalf("watcher was promoted by a request that was refused")
+	}
+
+	// the host can
+	if _, err := s.handlepromotespectator(hostIn, messages.PromoteSpectatorMessage{ServerPlayerID: host.GUID, TargetPlayerID: watcher.GUID, RoomCode: lobby.RoomCode}); err != nil {
+		t.Fatalf("promoting the watcher as the host returned an error: %v", err)
+	}
+	if isSpectating(&lobby.RegisteredInstance, watcher) {
+		t.Errorf("watcher is still spectating after being promoted")
+	}
+
+	// the same goes for joining a game directly
+	game := states.NewGameState()
+	s.Games.Store(game.GUID, game)
+	for _, spectate := range []bool{true, false} {
+		if _, err := s.handleaddplayergame(watcherIn, messages.AddPlayerGameMessage{ServerPlayerID: watcher.GUID, GameID: game.GUID, Spectate: spectate}); err != nil {
+			t.Fatalf("joining the game with spectate = %v returned an error: %v", spectate, err)
+		}
+		if isSpectating(&game.RegisteredInstance, watcher) != spectate {
+			t.Errorf("after joining the game with spectate = %v, watcher has spectating = %v", spectate, !spectate)
+		}
+	}
+
+	// a host who moves off the court to watch hands the host role over
+	join(host, hostIn, true)
+	if lobby.HostID != watcher.GUID {
+		t.Errorf("lobby host = %s after the host started spectating; want the remaining player %s", lobby.HostID, watcher.GUID)
+	}
+	if _, err := s.handleaddplayergame(watcherIn, messages.AddPlayerGameMessage{ServerPlayerID: watcher.GUID, GameID: game.GUID, Spectate: true}); err != nil {
+		t.Fatalf("spectating the game returned an error: %v", err)
+	}
+	if len(game.HostID) > 0 {
+		t.Errorf("game host = %s after its only player started spectating; want none", game.HostID)
+	}
+}
+
+// check that the status page summarizes round trip times without listing the players they belong to
+func TestStatusSummarizesRTT(t *testing.T) {
+	s := NewServerData()
+	players := []*states.PlayerState{}
+	for _, rttMs := range []int64{20, 40, 90, -1} {
+		player := states.NewPlayer("")
+		if rttMs >= 0 {
+			player.Latency.ReplySent(1000)
+			player.Latency.Measure(1000, 0, 0, 1000+rttMs)
+		}
+		s.Players.Store(player.GUID, player)
+		players = append(players, player)
+	}
+	w := httptest.NewRecorder()
+	s.HandleStatus(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+	body := w.Body.String()
+	if want := "Round trip times measured: 3, 50 ms mean, 90 ms p95"; !strings.Contains(body, want) {
+		t.Errorf("status page does not contain %q:\n%s", want, body)
+	}
+	for _, player := range players {
+		if strings.Contains(body, player.GUID) {
+			t.Errorf("status page lists player id %s:\n%s", player.GUID, body)
+		}
+	}
+}
+
+// check that a disconnected player can resume their session once with their token, and is sent the state of their lobby and game
+func TestResumeSession(t *testing.T) {
+	s := NewServerData()
+	lobby := newTestLobby(s)
+	host, hostIn := connectTestPlayer(s)
+	guest, guestIn := connectTestPlayer(s)
+	joinTestLobby(t, s, lobby, host, hostIn)
+	joinTestLobby(t, s, lobby, guest, guestIn)
+	game := s.startLobbyMatch(lobby, states.GameRules{})
+	token := s.issueResumeToken(host)
+
+	// the host drops, and comes back on a new connection
+	s.processdisconnect(hostIn.sess)
+	newIn := connectTestSession(s)
+	if _, err := s.handleadmitplayer(newIn, messages.AdmissionMessage{ClientPlayerID: 1, ResumeToken: token}); err != nil {
+		t.Fatalf("resuming the session returned an error: %v", err)
+	}
+	if host.GetSessionID() != newIn.sess.ID || host.RoomCode != lobby.RoomCode || host.GameID != game.GUID || lobby.HostID != host.GUID {
+		t.Fatalf("after resuming, host is on session %s in lobby %s and game %s, lobby host = %s; want them back as they were on %s", host.GetSessionID(), host.RoomCode, host.GameID, lobby.HostID, newIn.sess.ID)
+	}
+
+	// they get a fresh token along with the state they missed
+	newToken := host.GetResumeToken()
+	if len(newToken) == 0 || newToken == token {
+		t.Errorf("resume token after resuming = %q; want a new one in place of %q", newToken, token)
+	}
+	sent := drainQueue(newIn.sess)
+	for _, want := range []string{"messages.AdmissionMessage", newToken, "messages.ForcePlayerMessage", "messages.SyncHostMessage", "messages.PlayerIncludeMessage", guest.GUID, "messages.ScoreMessage", game.GUID} {
+		if !strings.Contains(sent, want) {
+			t.Errorf("resumed connection was not sent %s:\n%s", want, sent)
+		}
+	}
+
+	// tokens are kept out of the logs, whether presented by the client or handed out by the server
+	for _, msg := range []messages.AdmissionMessage{{ResumeToken: token}, {ServerPlayerID: host.GUID, ResumeToken: newToken}} {
+		body, _ := structures.JSONCodec.Encode(msg, "")
+		if desc := describews(structures.JSONCodec, body); strings.Contains(desc, msg.ResumeToken) {
+			t.Errorf("logged description of an admission = %s; want the resume token left out", desc)
+		}
+	}
+
+	// the old token can't be used again
+	res, err := s.handleadmitplayer(newIn, messages.AdmissionMessage{ClientPlayerID: 2, ResumeToken: token})
+	if reply, ok := res.(messages.AdmissionMessage); err != nil || !ok || len(reply.ErrMsg) == 0 || len(reply.ServerPlayerID) > 0 {
+		t.Errorf("resuming with a used token = %+v, %v; want it refused", res, err)
+	}
+	if host.GetSessionID() != newIn.sess.ID {
+		t.Errorf("host moved to session %s after a refused resume", host.GetSessionID())
+	}
+
+	// a grace period that ends after the player came back doesn't remove them
+	disconnectTime := host.MarkDisconnected()
+	host.Reattach(newIn.sess.ID)
+	s.removeDisconnectedPlayer(host, disconnectTime)
+	if _, err := s.FindPlayer(host.GUID); err != nil {
+		t.Errorf("player was removed after resuming their session: %v", err)
+	}
+
+	// one that ends while they are still gone removes them, along with their token
+	disconnectTime = guest.MarkDisconnected()
+	guestToken := s.issueResumeToken(guest)
+	s.removeDisconnectedPlayer(guest, disconnectTime)
+	if _, err := s.FindPlayer(guest.GUID); err == nil {
+		t.Errorf("player is still on the server after their grace period")
+	}
+	if _, err := s.FindPlayerByResumeToken(guestToken); err == nil {
+		t.Errorf("resume token still works after the grace period")
+	}
+	if isPlayer, _ := instanceRole(&lobby.RegisteredInstance, guest.GUID); isPlayer {
+		t.Errorf("player is still in the lobby after their grace period")
+	}
+	if isPlayer, _ := instanceRole(&game.RegisteredInstance, guest.GUID); isPlayer {
+		t.Errorf("player is still in the game after their grace period")
+	}
+}
+
+// check that players are attached to the session of the connection they were admitted on, even when connections share an address, and detached once it drops
+func TestPlayersAttachedToSessions(t *testing.T) {
+	s := NewServerData()
+	srv := httptest.NewServer(http.HandlerFunc(s.HandleWS))
+	defer srv.Close()
+
+	// admit a player on each of two connections from the same address
+	admit := func() *websocket.Conn {
+		client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+		if err != nil {
+			t.Fatalf("Error dialing test server: %v", err)
+		}
+		body, err := structures.JSONCodec.Encode(messages.AdmissionMessage{Attributes: states.PlayerAttributes{DisplayName: "Ace"}}, "")
+		if err != nil {
+			t.Fatalf("Error encoding admission: %v", err)
+		}
+		if err := client.WriteMessage(websocket.TextMessage, body); err != nil {
+			t.Fatalf("Error sending admission: %v", err)
+		}
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			_, reply, err := client.ReadMessage()
+			if err != nil {
+				t.Fatalf("Error reading admission reply: %v", err)
+			}
+			if strings.Contains(string(reply), "messages.AdmissionMessage") {
+				return client
+			}
+		}
+	}
+	first, second := admit(), admit()
+	defer second.Close()
+	players := map[string]*states.PlayerState{}
+	s.Players.Range(func(_, value any) bool {
+		player := value.(*states.PlayerState)
+		if _, err := s.FindSession(player.GetSessionID()); err != nil {
+			t.Errorf("player %s is attached to session %q, which is not open: %v", player.GUID, player.GetSessionID(), err)
+		}
+		players[player.GetSessionID()] = player
+		return true
+	})
+	if len(players) != 2 {
+		t.Fatalf("players on distinct sessions = %d; want 2", len(players))
+	}
+
+	// dropping the first connection detaches only its player, who is kept for their grace period
+	firstAddr := first.LocalAddr().String()
+	first.Close()
+	var dropped *states.PlayerState
+	for deadline := time.Now().Add(5 * time.Second); dropped == nil && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		for sessID, player := range players {
+			if _, err := s.FindSession(sessID); err != nil && player.IsDisconnected() {
+				dropped = player
+			}
+		}
+	}
+	if dropped == nil {
+		t.Fatalf("no player was detached after the connection from %s dropped", firstAddr)
+	}
+	for _, player := range players {
+		if _, err := s.FindPlayer(player.GUID); err != nil {
+			t.Errorf("player %s was removed right after a disconnect: %v", player.GUID, err)
+		}
+		if player != dropped && player.IsDisconnected() {
+			t.Errorf("player %s on the open connection was detached", player.GUID)
+		}
+	}
+}
+
+// check that players can only add themselves to a lobby or game, and not others by giving their ids
+func TestJoinOnlySelf(t *testing.T) {
+	s := NewServerData()
+	lobby := newTestLobby(s)
+	game := states.NewGameState()
+	s.Games.Store(game.GUID, game)
+	player, _ := connectTestPlayer(s)
+	_, otherIn := connectTestPlayer(s)
+
+	if _, err := s.handleaddplayerlobby(otherIn, messages.AddPlayerLobbyMessage{ServerPlayerID: player.GUID, RoomCode: lobby.RoomCode}); err == nil {
+		t.Errorf("adding a player to a lobby from another connection returned no error")
+	}
+	if _, err := s.handleaddplayergame(otherIn, messages.AddPlayerGameMessage{ServerPlayerID: player.GUID, GameID: game.GUID, Spectate: true}); err == nil {
+		t.Errorf("adding a player to a game from another connection returned no error")
+	}
+	if len(player.RoomCode) > 0 || len(player.GameID) > 0 || player.IsSpectator || len(lobby.HostID) > 0 {
+		t.Errorf("player = %+v after refused joins; want them left where they were", player)
+	}
+	if isPlayer, isSpectator := instanceRole(&game.RegisteredInstance, player.GUID); isPlayer || isSpectator {
+		t.Errorf("player was added to the game by a refused join")
+	}
+}
+
+// check that players can only remove themselves from a lobby or game, and not others by giving their ids
+func TestLeaveOnlySelf(t *testing.T) {
+	s := NewServerData()
+	lobby := newTestLobby(s)
+	host, hostIn := connectTestPlayer(s)
+	guest, guestIn := connectTestPlayer(s)
+	joinTestLobby(t, s, lobby, host, hostIn)
+	joinTestLobby(t, s, lobby, guest, guestIn)
+
+	// the guest can't remove the host from the lobby
+	if _, err := s.handleleavelobby(guestIn, messages.LeaveLobbyMessage{PlayerServerID: host.GUID, RoomCode: lobby.RoomCode}); err == nil {
+		t.Errorf("removing the host from the guest's connection returned no error")
+	}
+	if _, err := s.FindPlayer(host.GUID); err != nil || lobby.HostID != host.GUID {
+		t.Fatalf("after a refused leave request, host lookup = %v and lobby host = %s; want the host kept", err, lobby.HostID)
+	}
+	if isPlayer, _ := instanceRole(&lobby.RegisteredInstance, host.GUID); !isPlayer {
+		t.Fatalf("host was removed from the lobby by a refused leave request")
+	}
+
+	// nor from a game
+	game := states.NewGameState()
+	s.Games.Store(game.GUID, game)
+	joinTestGame(t, s, game, host, hostIn)
+	joinTestGame(t, s, game, guest, guestIn)
+	if _, err := s.handleleavegame(guestIn, messages.LeaveGameMessage{PlayerServerID: host.GUID, GameID: game.GUID}); err == nil {
+		t.Errorf("removing the host from the game from the guest's connection returned no error")
+	}
+	if isPlayer, _ := instanceRole(&game.RegisteredInstance, host.GUID); !isPlayer || game.HostID != host.GUID {
+		t.Errorf("after a refused leave request, host in game = %t and game host = %s; want the host kept", isPlayer, game.HostID)
+	}
+
+	// the guest can leave on their own
+	if _, err := s.handleleavelobby(guestIn, messages.LeaveLobbyMessage{PlayerServerID: guest.GUID, RoomCode: lobby.RoomCode}); err != nil {
+		t.Fatalf("leaving the lobby returned an error: %v", err)
+	}
+	if isPlayer, _ := instanceRole(&lobby.RegisteredInstance, guest.GUID); isPlayer {
+		t.Errorf("guest is still in the lobby after leaving")
+	}
+}
+
+// check that movement is only accepted from a player of the instance on their own connection, so that it can't skip the movement checks or be pinned on someone else
+func TestPlayerActionsBoundToSession(t *testing.T) {
+	s := NewServerData()
+	game := states.NewGameState()
+	s.Games.Store(game.GUID, game)
+	player, playerIn := connectTestPlayer(s)
+	other, otherIn := connectTestPlayer(s)
+	joinTestGame(t, s, game, player, playerIn)
+	joinTestGame(t, s, game, other, otherIn)
+	outsider, outsiderIn := connectTestPlayer(s)
+	start := player.Pos.X
+	drainQueue(otherIn.sess)
+
+	for _, tc := range []struct {
+		name string
+		in   *inbound
+		id   string
+	}{
+		{"no player", otherIn, ""},
+		{"player on another connection", otherIn, player.GUID},
+		{"player outside the game", outsiderIn, outsider.GUID},
+	} {
+		move := messages.PlayerActionMessage{PlayerServerID: tc.id, GameID: game.GUID}
+		move.Action.Pos.X = -start
+		if _, err := s.handleplayeraction(tc.in, move); err == nil {
+			t.Errorf("action by %s was accepted", tc.name)
+		}
+	}
+	if player.Pos.X != start {
+		t.Errorf("player moved to %v by refused actions; want them left at %v", player.Pos.X, start)
+	}
+	if _, total, _ := player.Violations.Counts(); total != 0 {
+		t.Errorf("player has %d movement violations from actions sent by others; want none", total)
+	}
+	if drainForType(otherIn.sess, "messages.PlayerActionMessage") {
+		t.Errorf("refused actions were broadcast")
+	}
+
+	// spectators can't move either
+	if _, err := s.handleaddplayergame(outsiderIn, messages.AddPlayerGameMessage{ServerPlayerID: outsider.GUID, GameID: game.GUID, Spectate: true}); err != nil {
+		t.Fatalf("spectating the game returned an error: %v", err)
+	}
+	move := messages.PlayerActionMessage{PlayerServerID: outsider.GUID, GameID: game.GUID}
+	if _, err := s.handleplayeraction(outsiderIn, move); errorCode(err) != messages.ErrCodeSpectator {
+		t.Errorf("action by a spectator returned %v; want a spectator error", err)
+	}
+}
+
+// check that players can only switch themselves to a side with room, and not during a match
+func TestSwitchSide(t *testing.T) {
+	s := NewServerData()
+	lobby := newTestLobby(s)
+	host, hostIn := connectTestPlayer(s)
+	guest, guestIn := connectTestPlayer(s)
+	joinTestLobby(t, s, lobby, host, hostIn)
+	joinTestLobby(t, s, lobby, guest, guestIn)
+	host.Pos.X = -3
+	switchSide := func(in *inbound, player *states.PlayerState) error {
+		_, err := s.handleswitch(in, messages.SwitchSideMessage{ServerPlayerID: player.GUID, RoomCode: lobby.RoomCode})
+		return err
+	}
+
+	// the guest can't switch the host
+	if err := switchSide(guestIn, host); err == nil || host.Pos.X != -3 {
+		t.Errorf("switching the host from the guest's connection returned %v and left them at %v; want it refused", err, host.Pos.X)
+	}
+
+	// a full side can't be switched to
+	filler := []*states.PlayerState{}
+	for len(filler) < defs.MaxTeamPlayers-1 {
+		player, _ := connectTestPlayer(s)
+		player.Pos.X = 3
+		lobby.Players.Store(player.GUID, true)
+		filler = append(filler, player)
+	}
+	guest.Pos.X = 3
+	if err := switchSide(hostIn, host); errorCode(err) != messages.ErrCodeLobbyFull || host.Pos.X != -3 {
+		t.Errorf("switching to a full side returned %v and left the host at %v; want a lobby full error", err, host.Pos.X)
+	}
+	lobby.Players.Delete(filler[0].GUID)
+	if err := switchSide(hostIn, host); err != nil || host.Pos.X != 3 {
+		t.Errorf("switching to a side with room returned %v and left the host at %v; want them moved to 3", err, host.Pos.X)
+	}
+
+	// nobody switches during a match
+	s.startLobbyMatch(lobby, states.GameRules{})
+	if err := switchSide(hostIn, host); errorCode(err) != messages.ErrCodeMatchInProgress || host.Pos.X != 3 {
+		t.Errorf("switching during a match returned %v and left the host at %v; want a match in progress error", err, host.Pos.X)
+	}
+}
+
+// check that only the serving team can serve, and that a serve starts from the server's copy of the serving player's position
+func TestServeFromServingTeam(t *testing.T) {
+	s := NewServerData()
+	game := states.NewGameState()
+	s.Games.Store(game.GUID, game)
+	left, leftIn := connectTestPlayer(s)
+	right, rightIn := connectTestPlayer(s)
+	joinTestGame(t, s, game, left, leftIn)
+	joinTestGame(t, s, game, right, rightIn)
+	left.UpdatePlayerState(&states.PlayerAction{Pos: structures.Vector2{X: -5, Y: 1}})
+	right.UpdatePlayerState(&states.PlayerAction{Pos: structures.Vector2{X: 5, Y: 1}})
+	serve := func(in *inbound, player *states.PlayerState, pos structures.Vector2) error {
+		b := states.BallState{Pos: pos, Vel: structures.Vector2{X: 4, Y: 6}, GravityScale: 1, TouchedBy: player.GUID}
+		_, err := s.handleballevent(in, messages.BallStateMessage{Ball: b, GameID: game.GUID})
+		return err
+	}
+
+	// the left team serves first, so the right team can't
+	if err := serve(rightIn, right, structures.Vector2{X: 5, Y: 2}); errorCode(err) != messages.ErrCodeBallDenied || game.GetBallCopy() != nil {
+		t.Errorf("serve by the receiving team returned %v; want it denied", err)
+	}
+
+	// a serve out of the server's reach is denied
+	if err := serve(leftIn, left, structures.Vector2{X: 5, Y: 2}); errorCode(err) != messages.ErrCodeBallDenied || game.GetBallCopy() != nil {
+		t.Errorf("serve out of reach returned %v; want it denied", err)
+	}
+
+	// a serve within reach starts from the server's own position, and counts as the serving team's first touch
+	if err := serve(leftIn, left, structures.Vector2{X: -4, Y: 2}); err != nil {
+		t.Fatalf("serve by the serving team was denied: %v", err)
+	}
+	want := structures.Vector2{X: -5, Y: 1 + defs.ServeHeight}
+	if b := game.GetBallCopy(); b == nil || b.Pos != want || b.Vel.X != 4 || !b.IsAlive() {
+		t.Errorf("served ball = %+v; want it alive at %v with the client's velocity", b, want)
+	}
+	if touches := game.Touches.Snapshot(); touches.Team != states.TeamLeft || touches.Count != 1 || touches.LastTouchBy != left.GUID {
+		t.Errorf("touches after the serve = %+v; want the serve as the left team's first touch", touches)
+	}
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/states"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/structures"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Purpose: A session represents a single websocket connection to a client.
+// * Players are attached to a session by its ID, so that nothing depends on the remote address of the connection (which may be shared or rewritten by proxies)
+// * Each session owns a writer goroutine fed by a bounded queue, since a websocket connection does not support concurrent writers
+// * Each session encodes its messages with the codec negotiated by its client, which is json until the client asks otherwise
+
+// the policies for handling a client that can't keep up with the messages being sent to it
+type SlowClientPolicy int
+
+const (
+	DropTransientFrames  SlowClientPolicy = iota // drop the oldest queued transient frames (e.g. player actions) to make room, and disconnect only if there are none to drop
+	DisconnectSlowClient                         // disconnect the client as soon as its queue is full
+)
+
+// returns the slow client policy with the given name ("drop" or "disconnect"), or false if there is none
+func SlowClientPolicyByName(name string) (SlowClientPolicy, bool) {
+	switch strings.ToLower(name) {
+	case "drop":
+		return DropTransientFrames, true
+	case "disconnect":
+		return DisconnectSlowClient, true
+	default:
+		return DropTransientFrames, false
+	}
+}
+
+// a message waiting to be written to the connection
+type outboundFrame struct {
+	body      []byte
+	transient bool // whether the frame is superseded by later updates, and can be dropped if the client falls behind
+	binary    bool // whether the frame is written as a binary (rather than text) websocket message
+}
+
+// a single client connection, which owns the underlying websocket
+type Session struct {
+	ID        string          // the server-generated id of the session
+	Remote    string          // the remote address of the connection, for logging purposes only
+	conn      *websocket.Conn // the underlying websocket connection
+	codec     structures.Codec
+	policy    SlowClientPolicy
+	queueSize int
+	queue     []outboundFrame // frames waiting to be written
+	dropped   int             // the number of frames dropped because the client fell behind
+	notify    chan struct{}   // signals the writer that frames are waiting
+	closed    chan struct{}   // closed once the session is closed
+	closeOnce sync.Once
+	mu        sync.Mutex
+
+	snapshots     map[string]*snapshotHistory // the snapshots recently sent to the client (key: instance GUID)
+	snapshotMutex sync.Mutex
+
+	lobbyAttempts states.TokenBucket // the client's failed attempts to find or get into a lobby, which are throttled to stop room codes and passwords from being guessed
+
+	pingReply      int64 // the server send time of the latest ping reply sent on the connection, which the client echoes on its next ping; zero once echoed
+	pingReplyMutex sync.Mutex
+}
+
+// create a new session for a websocket connection, with a send queue of the given size
+func NewSession(conn *websocket.Conn, queueSize int, policy SlowClientPolicy) *Session {
+	return &Session{
+		ID:        uuid.New().String(),
+		Remote:    conn.RemoteAddr().String(),
+		conn:      conn,
+		codec:     structures.JSONCodec,
+		policy:    policy,
+		queueSize: queueSize,
+		notify:    make(chan struct{}, 1),
+		closed:    make(chan struct{}),
+	}
+}
+
+// returns a short description of the session for logging
+func (c *Session) String() string {
+	return c.Remote + "/" + c.ID[:8]
+}
+
+// expose the codec used to encode messages sent to the client
+func (c *Session) SetCodec(codec structures.Codec) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.codec = codec
+}
+func (c *Session) Codec() structures.Codec {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.codec
+}
+
+// add a frame to the send queue without blocking; returns false if it could not be queued
+func (c *Session) enqueue(frame outboundFrame) bool {
+	if c.IsClosed() {
+		return false
+	}
+	c.mu.Lock()
+
+	// make room if the client has fallen behind
+	if len(c.queue) >= c.queueSize && !c.makeRoom(frame) {
+		c.mu.Unlock()
+		if frame.transient && c.policy == DropTransientFrames {
+			return false
+		}
+		log.Printf("[%s] Disconnecting client whose send queue is full", c)
+		c.Close()
+		return false
+	}
+	c.queue = append(c.queue, frame)
+	c.mu.Unlock()
+
+	// wake the writer up
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// try to free up a slot in the full queue for the frame according to the session's policy; must be called with the lock held
+func (c *Session) makeRoom(frame outboundFrame) bool {
+	if c.policy != DropTransientFrames {
+		return false
+	}
+	for i, queued := range c.queue {
+		if queued.transient {
+			c.queue = append(c.queue[:i], c.queue[i+1:]...)
+			c.dropped++
+			return true
+		}
+	}
+	if frame.transient {
+		c.dropped++
+	}
+	return false
+}
+
+// write queued frames to the connection until the session is closed
+func (c *Session) writepump() {
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-c.notify:
+		}
+
+		// take everything that is waiting
+		c.mu.Lock()
+		frames := c.queue
+		c.queue = nil
+		c.mu.Unlock()
+
+		// write it out
+		for _, frame := range frames {
+			msgType := websocket.TextMessage
+			if frame.binary {
+				msgType = websocket.BinaryMessage
+			}
+			if err := c.conn.WriteMessage(msgType, frame.body); err != nil {
+				log.Printf("[%s] Error writing message: %v", c, err)
+				c.Close()
+				return
+			}
+		}
+	}
+}
+
+// returns the number of frames dropped because the client fell behind
+func (c *Session) DroppedFrames() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dropped
+}
+
+// close the session and its connection; safe to call more than once
+func (c *Session) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.conn.Close()
+	})
+}
+
+// returns whether the session has been closed
+func (c *Session) IsClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// record the server send time of a ping reply sent on the connection
+func (c *Session) recordPingReply(serverSendTime int64) {
+	c.pingReplyMutex.Lock()
+	defer c.pingReplyMutex.Unlock()
+	c.pingReply = serverSendTime
+}
+
+// returns whether an echoed server send time is that of the latest ping reply sent on the connection
+// * each reply can only be echoed once, so that a client can't measure its round trip again against a reply of its choosing
+func (c *Session) takePingReply(serverSendTime int64) bool {
+	c.pingReplyMutex.Lock()
+	defer c.pingReplyMutex.Unlock()
+	if c.pingReply == 0 || c.pingReply != serverSendTime {
+		return false
+	}
+	c.pingReply = 0
+	return true
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/defs"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/messages"
+	"github.com/Isthatok74/PaperVolleyballServer/internal/pkg/states"
+)
+
+// This file contains the tick loop that sends batched snapshots of an instance's players and ball
+// * Instances created with a snapshot rate get one snapshot per tick, instead of every player action being relayed to every player as it arrives
+// * Each client is sent only the changes since the latest snapshot it acknowledged, or the full snapshot if it has not acknowledged one that is still held
+
+// the instance that a snapshot loop runs for
+type snapshotSource struct {
+	r        *states.RegisteredInstance
+	gameID   string
+	roomCode string
+	exists   func() bool              // whether the instance is still on the server
+	paused   func() bool              // whether snapshots should be skipped for now (e.g. while a lobby's players are in a match); may be nil
+	ball     func() *states.BallState // returns a copy of the instance's game ball, if any; may be nil
+}
+
+// the actions of an instance's players in a snapshot (key: player.GUID)
+type playerActions map[string]states.PlayerAction
+
+// the snapshots recently sent to a session for one instance
+type snapshotHistory struct {
+	sent  map[uint64]playerActions // the players' actions in each snapshot still held, by tick
+	acked uint64                   // the latest tick acknowledged by the client; zero if none
+}
+
+// returns a snapshot rate that the server supports, given a requested one
+func clampSnapshotHz(hz int) int {
+	return min(max(hz, 0), defs.MaxSnapshotHz)
+}
+
+// send snapshots of an instance at its snapshot rate until it is removed from the server
+func (s *ServerData) runSnapshots(src snapshotSource) {
+	if src.r.SnapshotHz <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Second / time.Duration(src.r.SnapshotHz))
+	defer ticker.Stop()
+
+	var tick uint64
+	for range ticker.C {
+
+		// stop once the instance no longer exists, and let go of the snapshots held for it
+		if !src.exists() {
+			log.Printf("Stopping snapshots for instance %s", src.r.GUID)
+			s.Sessions.Range(func(_, value any) bool {
+				value.(*Session).forgetSnapshots(src.r.GUID)
+				return true
+			})
+			return
+		}
+		if src.paused != nil && src.paused() {
+			continue
+		}
+
+		// collect the latest state of the instance
+		players := s.collectPlayerActions(src.r)
+		var ball *states.BallState
+		if src.ball != nil {
+			ball = src.ball()
+		}
+		if len(players) == 0 && ball == nil {
+			continue
+		}
+		tick++
+
+		// send it out to each client against their own baseline
+		for _, sess := range s.instanceSessions(src.r) {
+			s.sendSnapshot(sess, src, tick, players, ball)
+		}
+	}
+}
+
+// send a session the snapshot of a tick, as changes against the latest snapshot that it acknowledged if possible
+func (s *ServerData) sendSnapshot(sess *Session, src snapshotSource, tick uint64, players playerActions, ball *states.BallState) {
+	var msg any
+	if baseTick, baseline, ok := sess.snapshotBaseline(src.r.GUID); ok {
+		deltas, removed := diffPlayerActions(baseline, players)
+		msg = messages.SnapshotDeltaMessage{
+			Tick:         tick,
+			BaselineTick: baseTick,
+			GameID:       src.gameID,
+			RoomCode:     src.roomCode,
+			Players:      deltas,
+			Removed:      removed,
+			Ball:         ball,
+		}
+	} else {
+		full := messages.SnapshotMessage{
+			Tick:     tick,
+			GameID:   src.gameID,
+			RoomCode: src.roomCode,
+			Players:  []messages.PlayerSnapshot{},
+			Ball:     ball,
+		}
+		for _, pid := range sortedPlayerIDs(players) {
+			full.Players = append(full.Players, messages.PlayerSnapshot{ServerPlayerID: pid, Action: players[pid]})
+		}
+		msg = full
+	}
+	sess.recordSnapshot(src.r.GUID, tick, players)
+
+	// a snapshot is superseded by the next one, so it may be dropped for clients that fall behind
+	src.r.CountBytesSent(s.sendwsTransient(sess, msg))
+}
+
+// collect the latest action of every player in an instance
+func (s *ServerData) collectPlayerActions(r *states.RegisteredInstance) playerActions {
+	players := make(playerActions)
+	r.Players.Range(func(pid, _ interface{}) bool {
+		player, err := s.FindPlayer(pid.(string))
+		if err == nil {
+			players[player.GUID] = player.PlayerAction
+		}
+		return true
+	})
+	return players
+}
+
+// compute the changes to the players of an instance since a baseline snapshot; returns the changed players and the ids of those who left
+func diffPlayerActions(baseline playerActions, current playerActions) ([]messages.PlayerDelta, []string) {
+	deltas := []messages.PlayerDelta{}
+	for _, pid := range sortedPlayerIDs(current) {
+		cur := current[pid]
+		delta := messages.PlayerDelta{ServerPlayerID: pid}
+		base, existed := baseline[pid]
+		changed := !existed
+		if !existed || base.Pos != cur.Pos {
+			delta.Pos, changed = &cur.Pos, true
+		}
+		if !existed || base.Vel != cur.Vel {
+			delta.Vel, changed = &cur.Vel, true
+		}
+		if !existed || base.FaceRight != cur.FaceRight {
+			delta.FaceRight, changed = &cur.FaceRight, true
+		}
+		if !existed || base.Anim != cur.Anim {
+			delta.Anim, changed = &cur.Anim, true
+		}
+		if !existed || base.AxisX != cur.AxisX {
+			delta.AxisX, changed = &cur.AxisX, true
+		}
+		if changed {
+			deltas = append(deltas, delta)
+		}
+	}
+	removed := []string{}
+	for _, pid := range sortedPlayerIDs(baseline) {
+		if _, ok := current[pid]; !ok {
+			removed = append(removed, pid)
+		}
+	}
+	return deltas, removed
+}
+
+// returns the ids of the players in a snapshot in a stable order
+func sortedPlayerIDs(players playerActions) []string {
+	ids := make([]string, 0, len(players))
+	for pid := range players {
+		ids = append(ids, pid)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// keep the snapshot of a tick sent to the session, dropping those too old to be used as a baseline
+func (c *Session) recordSnapshot(instanceID string, tick uint64, players playerActions) {
+	c.snapshotMutex.Lock()
+	defer c.snapshotMutex.Unlock()
+	if c.snapshots == nil {
+		c.snapshots = make(map[string]*snapshotHistory)
+	}
+	history, ok := c.snapshots[instanceID]
+	if !ok {
+		history = &snapshotHistory{sent: make(map[uint64]playerActions)}
+		c.snapshots[instanceID] = history
+	}
+	history.sent[tick] = players
+	for t := range history.sent {
+		if t+defs.SnapshotHistorySize <= tick {
+			delete(history.sent, t)
+		}
+	}
+}
+
+// mark a snapshot as received by the client; returns false if it is not one that is still held
+func (c *Session) ackSnapshot(instanceID string, tick uint64) bool {
+	c.snapshotMutex.Lock()
+	defer c.snapshotMutex.Unlock()
+	history, ok := c.snapshots[instanceID]
+	if !ok {
+		return false
+	}
+	if _, ok := history.sent[tick]; !ok {
+		return false
+	}
+	history.acked = max(history.acked, tick)
+	return true
+}
+
+// returns the latest snapshot acknowledged by the client that is still held, to send changes against
+func (c *Session) snapshotBaseline(instanceID string) (uint64, playerActions, bool) {
+	c.snapshotMutex.Lock()
+	defer c.snapshotMutex.Unlock()
+	history, ok := c.snapshots[instanceID]
+	if !ok || history.acked == 0 {
+		return 0, nil, false
+	}
+	baseline, ok := history.sent[history.acked]
+	return history.acked, baseline, ok
+}
+
+// drop the snapshots held for an instance
+func (c *Session) forgetSnapshots(instanceID string) {
+	c.snapshotMutex.Lock()
+	defer c.snapshotMutex.Unlock()
+	delete(c.snapshots, instanceID)
+}